@@ -0,0 +1,116 @@
+package cachefetcher
+
+import (
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsyncWriteWorkers   = 4
+	defaultAsyncWriteQueueSize = 1024
+	opAsyncWrite               = "asyncwrite"
+)
+
+// asyncWriter is the bounded worker pool of Options.AsyncWrite, shared by the fetchers with the same Options.
+// Each key is routed to one worker by the key hash, so the writes to the same key run in order and the last write wins.
+type asyncWriter struct {
+	queues []chan func()
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int
+
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newAsyncWriter(workers, size int) *asyncWriter {
+	w := &asyncWriter{queues: make([]chan func(), workers)}
+	w.cond = sync.NewCond(&w.mu)
+
+	size = (size + workers - 1) / workers
+	for i := range w.queues {
+		w.queues[i] = make(chan func(), size)
+		go w.run(w.queues[i])
+	}
+	return w
+}
+
+func (w *asyncWriter) run(queue chan func()) {
+	for fn := range queue {
+		fn()
+		w.done()
+	}
+}
+
+func (w *asyncWriter) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending--
+	if w.pending == 0 {
+		w.cond.Broadcast()
+	}
+}
+
+// enqueue blocks while the queue of the key is full. It is the backpressure to the writers.
+// fn runs synchronously after close.
+func (w *asyncWriter) enqueue(key string, fn func()) {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		fn()
+		return
+	}
+
+	w.mu.Lock()
+	w.pending++
+	w.mu.Unlock()
+
+	w.queues[crc32.ChecksumIEEE([]byte(key))%uint32(len(w.queues))] <- fn
+}
+
+// flush waits until the pending writes are done.
+func (w *asyncWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.pending > 0 {
+		w.cond.Wait()
+	}
+}
+
+// close flushes the pending writes and stops the workers.
+func (w *asyncWriter) close() {
+	w.flush()
+
+	w.closeMu.Lock()
+	defer w.closeMu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	for _, q := range w.queues {
+		close(q)
+	}
+}
+
+// setAsync enqueues the write of the encoded value v.
+// The error is notified to the hook with "asyncwrite" op, because Set has already returned.
+func (f *cacheFetcherImpl) setAsync(v, value interface{}, expiration time.Duration) {
+	key := f.key // the fetcher may be reused for another key before the write.
+	f.options.writer.enqueue(key, func() {
+		if err := f.withBreaker(func() error { return f.client.Set(key, v, expiration) }); err != nil {
+			f.notify(Event{Op: opAsyncWrite, Key: key, Err: f.withGivenKey(key, err)})
+			return
+		}
+		f.setDone(key, value)
+	})
+}
+
+// Flush waits until the pending AsyncWrite writes are done, e.g. on shutdown.
+// It is no-op without AsyncWrite.
+func (f *cacheFetcherImpl) Flush() {
+	if f.options.writer != nil {
+		f.options.writer.flush()
+	}
+}
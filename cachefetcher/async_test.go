@@ -0,0 +1,167 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// blockingClient is a test client whose Set waits for release.
+type blockingClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	release chan struct{}
+}
+
+func (c *blockingClient) Set(key string, value interface{}, expiration time.Duration) error {
+	<-c.release
+	return c.SimpleRedisClientImpl.Set(key, value, expiration)
+}
+
+// slowFirstClient is a test client whose first Set is slow, so that the later write may overtake it.
+type slowFirstClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	calls int32
+}
+
+func (c *slowFirstClient) Set(key string, value interface{}, expiration time.Duration) error {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return c.SimpleRedisClientImpl.Set(key, value, expiration)
+}
+
+func TestAsyncWrite(t *testing.T) {
+	before()
+
+	client := &blockingClient{SimpleRedisClientImpl: redisClient, release: make(chan struct{})}
+	f := cachefetcher.NewFactory(client, &cachefetcher.Options{AsyncWrite: true}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "async"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// Set returns before the write.
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ok, err := f.Exists(); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	close(client.release)
+	f.Flush()
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "value" {
+		t.Errorf("%#v is not value", dst)
+	}
+}
+
+func TestAsyncWriteError(t *testing.T) {
+	before()
+
+	var mu sync.Mutex
+	var events []cachefetcher.Event
+	f := cachefetcher.NewFactory(&downClient{SimpleRedisClientImpl: redisClient}, &cachefetcher.Options{
+		AsyncWrite: true,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Err != nil {
+				events = append(events, e)
+			}
+		},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "async"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	f.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].Op != "asyncwrite" || !errors.Is(events[0].Err, errDown) {
+		t.Errorf("%#v", events)
+	}
+}
+
+func TestAsyncWriteOrder(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(&slowFirstClient{SimpleRedisClientImpl: redisClient}, &cachefetcher.Options{AsyncWrite: true}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "order"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("A", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f.Set("B", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	f.Flush()
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "B" {
+		t.Errorf("%#v is not B", dst)
+	}
+}
+
+func TestAsyncWriteClose(t *testing.T) {
+	before()
+
+	n := runtime.NumGoroutine()
+
+	f := cachefetcher.NewFactory(&closingClient{Client: redisClient}, &cachefetcher.Options{AsyncWrite: true, AsyncWriteWorkers: 8}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "close"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the workers are stopped.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if m := runtime.NumGoroutine(); m > n {
+		t.Errorf("%#v goroutines are leaked", m-n)
+	}
+
+	// the write after Close runs synchronously.
+	if err := f.Set("after", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "after" {
+		t.Errorf("%#v is not after", dst)
+	}
+}
@@ -0,0 +1,52 @@
+package cachefetcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func BenchmarkGet(b *testing.B) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "bench"); err != nil {
+		b.Fatalf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		b.Fatalf("%#v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst string
+		if err := f.Get(&dst); err != nil {
+			b.Fatalf("%#v", err)
+		}
+	}
+}
+
+func BenchmarkGetSkipSingleflightOnHit(b *testing.B) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{SkipSingleflightOnHit: true}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "bench"); err != nil {
+		b.Fatalf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		b.Fatalf("%#v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst string
+		if err := f.Get(&dst); err != nil {
+			b.Fatalf("%#v", err)
+		}
+	}
+}
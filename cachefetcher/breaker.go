@@ -0,0 +1,122 @@
+package cachefetcher
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// BreakerState is the state of the circuit breaker of Options.BreakerThreshold.
+	BreakerState string
+
+	// breaker is the circuit breaker held in Options. It counts the consecutive client errors.
+	breaker struct {
+		mu       sync.Mutex
+		failures int
+		openedAt time.Time // zero if closed.
+		probing  bool      // the probe call after the cooldown is running.
+	}
+)
+
+const (
+	// BreakerClosed calls the client.
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen short-circuits the client calls with ErrBreakerOpen.
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen calls the client once as the probe after the cooldown.
+	BreakerHalfOpen BreakerState = "half-open"
+
+	defaultBreakerCooldown = 30 * time.Second
+	opBreaker              = "breaker"
+)
+
+// ErrBreakerOpen is the client call short-circuited by the open circuit breaker.
+var ErrBreakerOpen = errors.New("cachefetcher: circuit breaker is open")
+
+// allow reports whether the client is called. After the cooldown, only one probe is allowed.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || now.Sub(b.openedAt) < cooldown {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// done records the result of the allowed call, and returns the new state if it is changed.
+func (b *breaker) done(failed bool, now time.Time, threshold int) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probing := b.probing
+	b.probing = false
+
+	if !failed {
+		b.failures = 0
+		if b.openedAt.IsZero() {
+			return "", false
+		}
+		b.openedAt = time.Time{}
+		return BreakerClosed, true
+	}
+
+	b.failures++
+	if !probing && (!b.openedAt.IsZero() || b.failures < threshold) {
+		return "", false
+	}
+
+	changed := b.openedAt.IsZero()
+	b.openedAt = now // the failed probe restarts the cooldown.
+	return BreakerOpen, changed
+}
+
+// state returns the current state.
+func (b *breaker) state() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openedAt.IsZero():
+		return BreakerClosed
+	case b.probing:
+		return BreakerHalfOpen
+	}
+	return BreakerOpen
+}
+
+// withBreaker calls fn unless the circuit breaker is open. The client errors other than cache miss are counted.
+// The state change is notified to the hook with "breaker" op, and the open state has ErrBreakerOpen as Err.
+func (f *cacheFetcherImpl) withBreaker(fn func() error) error {
+	b := f.options.breaker
+	if b == nil {
+		return fn()
+	}
+
+	cooldown := f.options.BreakerCooldown
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	if !b.allow(f.options.Clock.Now(), cooldown) {
+		return ErrBreakerOpen
+	}
+
+	err := fn()
+	state, changed := b.done(f.isErrOtherThanCacheMiss(err), f.options.Clock.Now(), f.options.BreakerThreshold)
+	switch {
+	case !changed:
+	case state == BreakerOpen:
+		f.notify(Event{Op: opBreaker, Key: f.key, Err: ErrBreakerOpen})
+	default:
+		f.notify(Event{Op: opBreaker, Key: f.key})
+	}
+	return err
+}
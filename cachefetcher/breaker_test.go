@@ -0,0 +1,149 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// flakyClient is a test client that fails while down, and counts the Get calls.
+type flakyClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	down  int32
+	calls int32
+}
+
+func (c *flakyClient) Get(key string, dst interface{}) error {
+	atomic.AddInt32(&c.calls, 1)
+	if atomic.LoadInt32(&c.down) == 1 {
+		return errDown
+	}
+	return c.SimpleRedisClientImpl.Get(key, dst)
+}
+
+// manualClock is a test clock that is advanced by the test, and never fires the timers.
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time { return nil }
+
+func (c *manualClock) Timer(d time.Duration) (<-chan time.Time, func()) { return nil, func() {} }
+
+func TestBreaker(t *testing.T) {
+	before()
+
+	client := &flakyClient{SimpleRedisClientImpl: redisClient, down: 1}
+	clock := &manualClock{now: time.Unix(0, 0)}
+	var events []cachefetcher.Event
+	f := cachefetcher.NewFactory(client, &cachefetcher.Options{
+		BreakerThreshold: 3,
+		BreakerCooldown:  10 * time.Second,
+		Clock:            clock,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			if e.Op == "breaker" {
+				events = append(events, e)
+			}
+		},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "breaker"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	for i := 0; i < 3; i++ {
+		if err := f.Get(&dst); !errors.Is(err, errDown) {
+			t.Errorf("%d: %#v", i, err)
+		}
+	}
+
+	if s := f.Stats().Breaker; s != cachefetcher.BreakerOpen {
+		t.Errorf("%#v", s)
+	}
+
+	if len(events) != 1 || !errors.Is(events[0].Err, cachefetcher.ErrBreakerOpen) {
+		t.Errorf("%#v", events)
+	}
+
+	// short-circuited without the client call.
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrBreakerOpen) {
+		t.Errorf("%#v", err)
+	}
+
+	if n := atomic.LoadInt32(&client.calls); n != 3 {
+		t.Errorf("%#v is not 3", n)
+	}
+
+	// the failed probe restarts the cooldown.
+	clock.advance(10 * time.Second)
+	if err := f.Get(&dst); !errors.Is(err, errDown) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrBreakerOpen) {
+		t.Errorf("%#v", err)
+	}
+
+	// the successful probe closes.
+	atomic.StoreInt32(&client.down, 0)
+	clock.advance(10 * time.Second)
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil || dst != "value" {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	if s := f.Stats().Breaker; s != cachefetcher.BreakerClosed {
+		t.Errorf("%#v", s)
+	}
+
+	if len(events) != 2 || events[1].Err != nil {
+		t.Errorf("%#v", events)
+	}
+}
+
+func TestBreakerFallbackToFetcher(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(&downClient{SimpleRedisClientImpl: redisClient}, &cachefetcher.Options{
+		BreakerThreshold:         1,
+		FallbackToFetcherOnError: true,
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "breaker", "fallback"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		var dst string
+		if err := f.Fetch(10*time.Second, &dst, func() (string, error) { return "fetched", nil }); err != nil {
+			t.Errorf("%d: %#v", i, err)
+		}
+
+		if dst != "fetched" {
+			t.Errorf("%d: %#v", i, dst)
+		}
+	}
+
+	if s := f.Stats().Breaker; s != cachefetcher.BreakerOpen {
+		t.Errorf("%#v", s)
+	}
+}
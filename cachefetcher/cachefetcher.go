@@ -2,15 +2,23 @@
 package cachefetcher
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"reflect"
 	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/k0kubun/pp"
@@ -27,33 +35,232 @@ type (
 	CacheFetcher interface {
 		SetKey(prefixes []string, elements ...interface{}) error
 		SetHashKey(prefixes []string, elements ...interface{}) error
+		SetGroupKey(key string)
+		SetKeyWithTags(prefixes, tags []string, elements ...interface{}) error
+		BuildKey(prefixes []string, elements ...interface{}) (string, error)
+		BuildHashKey(prefixes []string, elements ...interface{}) (string, error)
 		Key() string
+		KeyParts() (prefixes []string, element string)
 
 		Fetch(expiration time.Duration, dst interface{}, fetcher interface{}) error
+		FetchAt(expireAt time.Time, dst interface{}, fetcher interface{}) error
+		FetchRefreshAhead(expiration time.Duration, dst interface{}, fetcher interface{}) error
+		FetchWithContext(ctx context.Context, expiration time.Duration, dst interface{}, fetcher interface{}) error
+		FetchInto(expiration time.Duration, dst interface{}, fetcher interface{}) error
+		LockedFetch(expiration, lockTTL time.Duration, dst interface{}, fetcher interface{}) error
+		GetOrSet(expiration time.Duration, dst interface{}, fetcher interface{}) (fromCache bool, err error)
 		Set(value interface{}, expiration time.Duration) error
+		SetAt(value interface{}, expireAt time.Time) error
+		ForceSet(value interface{}, expiration time.Duration) error
+		SetIfNewer(value interface{}, version int64, expiration time.Duration) (bool, error)
 		Get(dst interface{}) error
+		GetWithTTL(dst interface{}) (time.Duration, error)
+		GetInto(dsts ...interface{}) error
+		GetMany(keys []string, newDst func(key string) interface{}) (map[string]interface{}, error)
+		FetchMulti(keys []string, expiration time.Duration, newDst func(key string) interface{}, fetcher MultiFetcherFunc) (map[string]interface{}, map[string]error)
 		SetString(value string, expiration time.Duration) error
 		GetString() (string, error)
+		SetBytes(b []byte, expiration time.Duration) error
+		GetBytes() ([]byte, error)
+		SetReader(r io.Reader, size int64, expiration time.Duration) error
+		GetReader() (io.ReadCloser, error)
+		SetHash(value interface{}, expiration time.Duration) error
+		GetHash(dst interface{}) error
+		GetHashField(field string, dst interface{}) error
+		AppendToList(value interface{}, expiration time.Duration) error
+		GetList(dst interface{}) error
 		Del() error
+		DelIfEquals(expected interface{}) (bool, error)
+		DelKeys(keys []string) error
+		DelByPattern(pattern string) (int, error)
+		InvalidateTag(tag string) error
+		Pipeline(fn func(p Pipeline)) error
+		Exists() (bool, error)
+		PeekRaw() (string, bool, error)
+		GetMeta() (Meta, error)
+		Scan(prefix string) ([]string, error)
 
 		GobRegister(value interface{})
 		IsCached() bool
+		Clone() CacheFetcher
+		Stats() Stats
+		Ping(ctx context.Context) error
+		Flush()
+		Close() error
 	}
 
 	// Client is needs implement.
 	Client interface {
 		Set(key string, value interface{}, expiration time.Duration) error
+		SetNX(key string, value interface{}, expiration time.Duration) (bool, error)
 		Get(key string, dst interface{}) error
 		Del(key string) error
+		DelMulti(keys []string) (int64, error) // the number of the keys deleted, like DEL.
+		Exists(key string) (bool, error)
+		Scan(match string) ([]string, error)
+		HSet(key string, fields map[string]string, expiration time.Duration) error
+		HGetAll(key string) (map[string]string, error)
+		HGet(key, field string) (string, error)
 		IsErrCacheMiss(err error) bool
 	}
 
+	// MultiGetter is optional for Client to get the multiple keys in one round trip, e.g. with MGET.
+	// The missing keys are omitted from the result. Without it, the keys are got by Get one by one.
+	MultiGetter interface {
+		MGet(keys []string) (map[string]string, error)
+	}
+
+	// Pinger is optional for Client to check the cache backend is reachable.
+	Pinger interface {
+		Ping(ctx context.Context) error
+	}
+
+	// Closer is optional for Client to release the resources, e.g. the connection pool.
+	Closer interface {
+		Close() error
+	}
+
+	// TieredClient is optional for Client to report which tier answered Get. e.g. the local LRU and Redis.
+	TieredClient interface {
+		GetWithTier(key string, dst interface{}) (Tier, error)
+	}
+
+	// Tier is the cache tier that answered Get.
+	Tier string
+
+	// CompareAndDeleter is optional for Client to delete the key atomically only if the stored value equals value.
+	CompareAndDeleter interface {
+		DelIfEquals(key string, value interface{}) (bool, error)
+	}
+
 	// Options is extended settings.
 	Options struct {
-		Group           *singleflight.Group
-		GroupTimeout    time.Duration
-		DebugPrintMode  bool
-		IsNotSerialized bool // serialize default with using gob serializer.
+		Group                    *singleflight.Group
+		DisableSingleflight      bool          // call Get and Fetch directly without Group.
+		SkipSingleflightOnHit    bool          // get the cache directly first in Get and Fetch, and use Group only on miss.
+		GroupKeyPrefix           string        // scope of the singleflight key. e.g. the tenant. the storage key is not changed.
+		GroupKeyFromContext      GroupKeyFunc  // scope of the singleflight key from FetchWithContext's ctx. e.g. the tenant.
+		BypassFunc               BypassFunc    // skip the cache read in FetchWithContext if it reports true for ctx. e.g. the admin request.
+		DisableForgetOnError     bool          // share an error with the concurrent callers until the call ends.
+		DefaultExpiration        time.Duration // expiration of UseDefault. default is NoExpiration.
+		TTLJitter                time.Duration // add the random duration up to it to the positive expiration, so the keys set at once do not expire at once.
+		GroupTimeout             time.Duration // timeout of the whole singleflight wait including the fetcher.
+		ClientTimeout            time.Duration // timeout of each client call. default is no timeout.
+		DebugPrintMode           bool
+		Logger                   Logger // print the lines of DebugPrintMode instead of stdout, e.g. *log.Logger.
+		IsNotSerialized          bool   // serialize default with using gob serializer.
+		DisableReplaceSpaces     bool   // keep the spaces in the key verbatim instead of replacing them with the separator.
+		RequirePrefixes          bool   // SetKey returns ErrEmptyPrefixes if all prefixes are empty.
+		LowercaseKeys            bool   // lowercase the whole key, e.g. for the case-insensitive emails. the hash is also case-stable.
+		KeyPrefix                string // namespace prepended to every key with the separator.
+		HashTag                  HashTagFunc
+		SchemaVersion            string // version appended to every key with the separator. bump it to orphan the old cache.
+		TimeKeyLayout            string // time.Time layout for key elements. default is time.Time's String() without monotonic clock.
+		KeyEncoder               KeyEncoder
+		KeyStructTag             string // struct tag for struct key element's field name. default is "json".
+		KeyStructOmitEmpty       bool   // skip zero value field tagged "omitempty" in struct key element.
+		UnambiguousCollections   bool   // encode array and slice key element to "[a,b]" instead of "a_b".
+		HashKeyReadablePrefixLen int    // keep the first runes of the element string before the hash in SetHashKey for debugging. default is 0.
+		DebugPrintHook           DebugPrintHook
+		TypeSerializers          map[reflect.Type]Serializer // serialize the value of the type, or the pointer to it, instead of Serializer. the ID must be unique.
+		OnSet                    OnSetFunc
+		Serializer               Serializer    // serialize the stored value except string and []byte. default is GobSerializer.
+		DecodeFallbacks          []Serializer  // deserialize with them in order when Serializer fails, e.g. the legacy gob value.
+		FormatHeader             bool          // prepend the format header of the serializer and the compressor to the stored value.
+		Compression              Compressor    // compress the stored value except SetString and SetBytes.
+		RawStringFetch           bool          // store string and []byte result of the fetcher as SetString does, without the header and compression.
+		MaxValueBytes            int           // refuse to store the encoded value over the bytes with ErrValueTooLarge. default is no limit.
+		SkipOversized            bool          // skip storing the value over MaxValueBytes without error. Fetch returns the value without cache.
+		FallbackToFetcherOnError bool          // call the fetcher without cache in Fetch when the cache backend fails.
+		AsyncWrite               bool          // set in the background by the bounded workers, and return immediately. see Flush.
+		AsyncWriteWorkers        int           // the number of the AsyncWrite workers. default is 4.
+		AsyncWriteQueueSize      int           // the bound of the pending AsyncWrite writes. Set blocks when it is full. default is 1024.
+		BreakerThreshold         int           // open the circuit breaker after the consecutive client errors. default is disabled.
+		BreakerCooldown          time.Duration // short-circuit the client calls with ErrBreakerOpen while open, then probe. default is 30s.
+		RefreshAhead             time.Duration // refresh the hit value older than it in FetchRefreshAhead in the background. the format header stores the write time.
+		StoreMeta                bool          // store the write time and SourceTag in the format header for GetMeta.
+		SourceTag                string        // the writer stored with StoreMeta, e.g. the process version.
+		AllowFullScan            bool          // allow DelByPattern's pattern that matches all keys, e.g. "*".
+		RefreshTTLOnHit          bool          // bump the expiration of the hit key in Fetch in the background. the client must implement Expirer.
+		IsCacheMiss              CacheMissFunc // detect the cache miss instead of the client's IsErrCacheMiss. e.g. for the wrapped clients.
+		TreatDecodeErrorAsMiss   bool          // treat the decode error in Fetch as cache miss, and overwrite it with the fetcher's result.
+		GobTypes                 []interface{} // concrete types registered to gob by NewFactory. e.g. the values of interface fields.
+		ExpvarName               string        // publish the counters of Stats to expvar as the map of the name, e.g. for /debug/vars.
+		Clock                    Clock         // time source of the timeouts and the elapsed time. default is the real clock.
+
+		stats   *stats
+		writer  *asyncWriter
+		breaker *breaker
+	}
+
+	// GroupKeyFunc returns the scope of the singleflight key from the context.
+	GroupKeyFunc func(ctx context.Context) string
+
+	// BypassFunc reports whether the request of the context bypasses the cache, e.g. with the admin header.
+	BypassFunc func(ctx context.Context) bool
+
+	// CacheMissFunc reports whether err is the cache miss.
+	CacheMissFunc func(err error) bool
+
+	// HashTagFunc returns the Redis Cluster hash tag of the key without KeyPrefix and SchemaVersion.
+	// The tag is wrapped in braces and inserted after KeyPrefix, e.g. "svcA_{tag}_prefix_key".
+	// The empty tag is not inserted.
+	HashTagFunc func(key string) string
+
+	// CacheKeyer is the key element that has its own canonical key, e.g. the domain object.
+	// The default encoder uses CacheKey in preference to MarshalText and String.
+	CacheKeyer interface {
+		CacheKey() string
+	}
+
+	// KeyEncoder encodes each key element. It replaces the default canonical encoder.
+	KeyEncoder func(element interface{}) (string, error)
+
+	// OnSetFunc is called after each successful set including the set in Fetch, SetHash and SetReader.
+	// value is the value before serialization, e.g. for the write-through side effects. SetReader's value is the consumed io.Reader.
+	OnSetFunc func(key string, value interface{})
+
+	// DebugPrintHook is called after each operation with the Event.
+	DebugPrintHook func(e Event)
+
+	// Logger prints the lines of DebugPrintMode, e.g. *log.Logger.
+	Logger interface {
+		Printf(format string, v ...interface{})
+	}
+
+	// Event is the result of an operation.
+	Event struct {
+		Op       string // e.g. "cachefetcher.(*cacheFetcherImpl).Get"
+		Key      string
+		IsCached bool
+		Shared   bool // singleflight's Result.Shared.
+		Waiters  int  // the number of the callers still waiting for the same key, including this caller.
+		Elapsed  time.Duration
+		Err      error // the operation's error, or the handled error e.g. the decode error treated as cache miss.
+		Tier     Tier  // the tier that answered Get. empty if the client is not TieredClient.
+	}
+
+	// result is singleflight.Result with the number of the waiters.
+	result struct {
+		singleflight.Result
+		Waiters int
+	}
+
+	waiterKey struct {
+		group *singleflight.Group
+		key   string
+	}
+
+	waiterCounter struct {
+		mu sync.Mutex
+		m  map[waiterKey]int
+	}
+
+	// KeyError is invalid key element error. It wraps ErrInvalidKeyElements.
+	KeyError struct {
+		Prefixes     []string
+		ElementIndex int          // index of the top level element.
+		Kind         reflect.Kind // kind of the invalid element.
 	}
 
 	factoryImpl struct {
@@ -65,45 +272,193 @@ type (
 		client  Client
 		options *Options
 
-		key      string
-		isCached bool // is used cache?
+		key              string
+		groupKeyOverride string // the singleflight key of SetGroupKey.
+		parts            keyParts
+
+		// the result of the last operation. It is guarded by mu, because the singleflight goroutine writes it,
+		// e.g. after the caller's timeout, and the fetcher may be shared.
+		mu        sync.Mutex
+		isCached  bool      // is used cache?
+		tier      Tier      // the tier that answered the last get.
+		writtenAt time.Time // the write time of the last got value with Options.RefreshAhead or StoreMeta.
+
+		inFlight int32 // the number of the background works that use the fetcher, e.g. after the caller's timeout.
+	}
+
+	// keyParts is the logical parts of the key for KeyParts.
+	keyParts struct {
+		prefixes []string
+		element  string
 	}
 )
 
 var (
 	defaultGroup = singleflight.Group{}
+	contextType  = reflect.TypeOf((*context.Context)(nil)).Elem()
+	waiters      = &waiterCounter{m: map[waiterKey]int{}}
+	globEscaper  = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
 
 	// ErrInvalidKeyElements is invalid for setting key.
 	ErrInvalidKeyElements = errors.New("cachefetcher: key elements is invalid")
 
+	// ErrEmptyPrefixes is all prefixes are empty with Options.RequirePrefixes.
+	ErrEmptyPrefixes = errors.New("cachefetcher: prefixes are empty")
+
+	// ErrEmptyKey is the operation of the fetcher's key without the key, e.g. the ignored SetKey error.
+	ErrEmptyKey = errors.New("cachefetcher: key is empty")
+
 	// ErrTimeout is singleflight's chan timeout.
 	ErrTimeout = errors.New("cachefetcher: timeout")
 
+	// ErrClientTimeout is client call's timeout.
+	ErrClientTimeout = errors.New("cachefetcher: client timeout")
+
+	// ErrInvalidExpiration is negative expiration except UseDefault. Use NoExpiration to persist forever.
+	ErrInvalidExpiration = errors.New("cachefetcher: invalid expiration")
+
 	// ErrNoPointerType is Get's dst type is no pointer.
 	ErrNoPointerType = errors.New("cachefetcher: no pointer type")
 
+	// ErrInterfaceType is Get's dst type is pointer to interface.
+	ErrInterfaceType = errors.New("cachefetcher: interface type, use concrete type pointer")
+
+	// ErrNoSliceType is FetchInto's dst type is not a pointer to slice.
+	ErrNoSliceType = errors.New("cachefetcher: no slice type")
+
+	// ErrFetchTypeMismatch is fetcher's result type is not assignable to dst.
+	ErrFetchTypeMismatch = errors.New("cachefetcher: fetch type mismatch")
+
+	// ErrNotPinger is the client does not implement Pinger.
+	ErrNotPinger = errors.New("cachefetcher: client is not pinger")
+
+	// ErrNotCompareAndDeleter is the client does not implement CompareAndDeleter.
+	ErrNotCompareAndDeleter = errors.New("cachefetcher: client is not compare and deleter")
+
+	// ErrInvalidNodes is NewShardedClient's nodes are empty or have nil client.
+	ErrInvalidNodes = errors.New("cachefetcher: invalid nodes")
+
 	// ErrGobSerialized failed to encode or decode of gob.
 	ErrGobSerialized = errors.New("cachefetcher: gob serialized failed")
+
+	// SkipCache is returned by fetcher function with the value to skip setting cache.
+	// Fetch returns the value without error, but the value is not cached.
+	SkipCache = errors.New("cachefetcher: skip cache")
+
+	// ErrNilFetchResult is fetcher function's result is nil pointer or nil interface.
+	ErrNilFetchResult = errors.New("cachefetcher: fetcher result is nil")
+
+	// ErrValueTooLarge is the encoded value is over Options.MaxValueBytes.
+	ErrValueTooLarge = errors.New("cachefetcher: value is too large")
+
+	// ErrFetcherPanic is fetcher function panicked. It wraps the recovered value with the stack.
+	ErrFetcherPanic = errors.New("cachefetcher: fetcher panicked")
+)
+
+const (
+	// TierL1 is the first tier, e.g. the local LRU.
+	TierL1 Tier = "L1"
+
+	// TierL2 is the second tier, e.g. Redis.
+	TierL2 Tier = "L2"
+
+	// TierMiss is missed in all tiers.
+	TierMiss Tier = "miss"
+)
+
+const (
+	// TimeKeyLayoutUnix is TimeKeyLayout for unix timestamp.
+	TimeKeyLayoutUnix = "unix"
+
+	// NoExpiration is the expiration that persists the cache forever.
+	NoExpiration = time.Duration(0)
+
+	// UseDefault is the expiration that uses Options.DefaultExpiration. It differs from NoExpiration.
+	UseDefault = time.Duration(math.MinInt64)
 )
 
 const (
-	defaultGroupTimeout = 5 * time.Minute
-	skip                = 1
-	sep                 = "_"
+	defaultGroupTimeout  = 5 * time.Minute
+	defaultTimeKeyLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+	defaultKeyStructTag  = "json"
+	lockSuffix           = "lock"
+	getOrSetSuffix       = "getorset"
+	getBytesSuffix       = "getbytes"
+	lockPollInterval     = 50 * time.Millisecond
+	opDecode             = "decode"
+	opBackend            = "backend"
+	groupKeySep          = "\x00"
+	reservedSep          = "\x00" // separates the internal keys derived from the key, e.g. the lock, from the keys of SetKey.
+	skip                 = 1
+	sep                  = "_"
 )
 
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%v: prefixes:%v, index:%d, kind:%v", ErrInvalidKeyElements, e.Prefixes, e.ElementIndex, e.Kind)
+}
+
+func (e *KeyError) Unwrap() error {
+	return ErrInvalidKeyElements
+}
+
 // NewCacheFetcher is new method for CacheFetcher.
+// The invalid options of Options.Validate are fixed and logged, e.g. the negative duration is 0.
+// NewFactoryWithOptions returns the error instead.
 func NewFactory(client Client, options *Options) Factory {
 	// default
 	if options == nil {
 		options = &Options{}
 	}
+	options.normalize()
 	if options.Group == nil {
 		options.Group = &defaultGroup
 	}
 	if options.GroupTimeout == 0 {
 		options.GroupTimeout = defaultGroupTimeout
 	}
+	if options.KeyStructTag == "" {
+		options.KeyStructTag = defaultKeyStructTag
+	}
+	if options.Clock == nil {
+		options.Clock = defaultClock
+	}
+	if options.Serializer == nil {
+		options.Serializer = &GobSerializer{}
+	}
+	if options.stats == nil {
+		options.stats = &stats{}
+	}
+	if options.ExpvarName != "" && options.stats.vars == nil {
+		options.stats.vars = publishExpvar(options.ExpvarName)
+	}
+	if options.AsyncWrite && options.writer == nil {
+		if options.AsyncWriteWorkers <= 0 {
+			options.AsyncWriteWorkers = defaultAsyncWriteWorkers
+		}
+		if options.AsyncWriteQueueSize <= 0 {
+			options.AsyncWriteQueueSize = defaultAsyncWriteQueueSize
+		}
+		options.writer = newAsyncWriter(options.AsyncWriteWorkers, options.AsyncWriteQueueSize)
+	}
+	if options.BreakerThreshold > 0 && options.breaker == nil {
+		options.breaker = &breaker{}
+	}
+	// normalize dropped the conflicting IDs, so the error is only of the concurrent registration.
+	sers := append([]Serializer{options.Serializer}, options.DecodeFallbacks...)
+	for _, s := range options.TypeSerializers {
+		sers = append(sers, s)
+	}
+	for _, s := range sers {
+		if err := RegisterSerializer(s); err != nil {
+			options.logf("cachefetcher: ignored the serializer: %v", err)
+		}
+	}
+	if options.Compression != nil {
+		RegisterCompressor(options.Compression)
+	}
+	for _, t := range options.GobTypes {
+		gobRegister(t)
+	}
 
 	return &factoryImpl{client: client, options: options}
 }
@@ -126,282 +481,1582 @@ func (f *cacheFetcherImpl) SetHashKey(prefixes []string, elements ...interface{}
 }
 
 func (f *cacheFetcherImpl) setKey(prefixes []string, elements []interface{}, useHash bool) error {
-	s := prefixes
+	key, parts, err := f.buildKey(prefixes, elements, useHash)
+	if err != nil {
+		return err
+	}
+
+	f.key = key
+	f.parts = parts
+	return nil
+}
+
+// SetGroupKey sets the singleflight key of Fetch independent of the storage key of SetKey, e.g. without the request ID.
+// Fetch and GetOrSet coalesce the concurrent calls of the same group key, and the callers share the first caller's result
+// read from or written to the first caller's storage key, so the group key must identify the result.
+// The empty group key uses the storage key.
+func (f *cacheFetcherImpl) SetGroupKey(key string) {
+	f.groupKeyOverride = key
+}
+
+// flightKey returns the singleflight key before the scope of groupKey.
+func (f *cacheFetcherImpl) flightKey() string {
+	if f.groupKeyOverride != "" {
+		return f.groupKeyOverride
+	}
+	return f.key
+}
+
+// BuildKey returns the key that SetKey would set, without setting it.
+func (f *cacheFetcherImpl) BuildKey(prefixes []string, elements ...interface{}) (string, error) {
+	key, _, err := f.buildKey(prefixes, elements, false)
+	return key, err
+}
+
+// BuildHashKey returns the key that SetHashKey would set, without setting it.
+func (f *cacheFetcherImpl) BuildHashKey(prefixes []string, elements ...interface{}) (string, error) {
+	key, _, err := f.buildKey(prefixes, elements, true)
+	return key, err
+}
+
+// KeyParts returns the prefixes and the element segment of the key set by SetKey, e.g. for the metrics labels.
+// The empty prefixes are dropped, and the element segment is the hash after SetHashKey.
+// They do not include Options.KeyPrefix, the hash tag and Options.SchemaVersion.
+func (f *cacheFetcherImpl) KeyParts() ([]string, string) {
+	return append([]string(nil), f.parts.prefixes...), f.parts.element
+}
+
+func (f *cacheFetcherImpl) buildKey(prefixes []string, elements []interface{}, useHash bool) (string, keyParts, error) {
+	var s []string
+	if f.options.KeyPrefix != "" {
+		s = append(s, f.options.KeyPrefix)
+	}
+
+	n := len(s)
+	var parts keyParts
+	for _, p := range prefixes {
+		if strings.TrimSpace(p) == "" {
+			continue // empty or whitespace-only prefix makes double separators.
+		}
+		s = append(s, p)
+		parts.prefixes = append(parts.prefixes, p)
+	}
+	if f.options.RequirePrefixes && len(s) == n {
+		return "", keyParts{}, fmt.Errorf("%w: %q", ErrEmptyPrefixes, prefixes)
+	}
+
 	if len(elements) > 0 {
 		e, err := f.toStringsForElements(elements...)
 		if err != nil {
-			return err
+			var ke *KeyError
+			if errors.As(err, &ke) {
+				ke.Prefixes = prefixes
+			}
+			return "", keyParts{}, err
+		}
+
+		if f.options.LowercaseKeys {
+			e = strings.ToLower(e) // before hashing, so that the hash is also case-stable.
 		}
 
 		h := e
 		if useHash {
 			b := sha256.Sum256([]byte(e))
 			h = hex.EncodeToString(b[:])
+			if n := f.options.HashKeyReadablePrefixLen; n > 0 {
+				h = readablePrefix(e, n) + sep + h // the hash is not shortened, so the uniqueness is kept.
+			}
 		}
 		s = append(s, h)
+		parts.element = h
 	}
 
-	f.key = strings.ReplaceAll(strings.Join(s, sep), " ", sep)
-	return nil
+	if f.options.HashTag != nil {
+		if tag := f.options.HashTag(strings.Join(s[n:], sep)); tag != "" {
+			s = append(s[:n], append([]string{"{" + tag + "}"}, s[n:]...)...)
+		}
+	}
+
+	if f.options.SchemaVersion != "" {
+		s = append(s, f.options.SchemaVersion)
+	}
+
+	key := strings.Join(s, sep)
+	if f.options.LowercaseKeys {
+		key = strings.ToLower(key)
+	}
+	if f.options.DisableReplaceSpaces {
+		return key, parts, nil
+	}
+	return strings.ReplaceAll(key, " ", sep), parts, nil
 }
 
-// Get key.
+// Get key. The key includes Options.KeyPrefix and Options.SchemaVersion.
 func (f *cacheFetcherImpl) Key() string {
 	return f.key
 }
 
+// readablePrefix returns the first n runes of the element string, not to split the multibyte character.
+func readablePrefix(e string, n int) string {
+	r := []rune(e)
+	if len(r) > n {
+		r = r[:n]
+	}
+	return string(r)
+}
+
 func (f *cacheFetcherImpl) toStringsForElements(elements ...interface{}) (string, error) {
 	if len(elements) == 0 {
 		return "", nil // no elements.
 	}
 
 	var el []string
-	var err error
+	for i, e := range elements {
+		s, err := f.encodeElement(e)
+		if err != nil {
+			return "", withElementIndex(err, i, e)
+		}
+		el = append(el, s)
+	}
 
-	for _, e := range elements {
-		if e == nil {
-			return "", ErrInvalidKeyElements
+	return strings.Join(el, sep), nil
+}
+
+// withElementIndex wraps ErrInvalidKeyElements with KeyError.
+// The index is overwritten by the outer elements, so it is the index of the top level element.
+func withElementIndex(err error, i int, e interface{}) error {
+	var ke *KeyError
+	if errors.As(err, &ke) {
+		ke.ElementIndex = i
+		return ke
+	}
+
+	if errors.Is(err, ErrInvalidKeyElements) {
+		v := reflect.ValueOf(e)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
 		}
+		return &KeyError{ElementIndex: i, Kind: v.Kind()}
+	}
+	return err
+}
 
-		switch v := reflect.ValueOf(e); reflect.TypeOf(e).Kind() {
-		case reflect.String, reflect.Bool, reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int8, reflect.Uint, reflect.Uint16,
-			reflect.Uint32, reflect.Uint64, reflect.Uint8, reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex128, reflect.Complex64:
+// encodeCollection encodes the elements of array and slice to "[a,b]",
+// so that []string{"a", "b"} does not collide with the two elements "a", "b".
+func (f *cacheFetcherImpl) encodeCollection(elements []interface{}) (string, error) {
+	el := make([]string, 0, len(elements))
+	for i, e := range elements {
+		s, err := f.encodeElement(e)
+		if err != nil {
+			return "", withElementIndex(err, i, e)
+		}
+		el = append(el, s)
+	}
+	return "[" + strings.Join(el, ",") + "]", nil
+}
 
-		case reflect.Ptr:
-			if e, err = f.toStringsForElements(v.Elem().Interface()); err != nil {
-				return "", err
-			}
+// encodeElement formats an element explicitly per kind instead of fmt's "%+v",
+// so that the key is stable across Go versions and machines.
+func (f *cacheFetcherImpl) encodeElement(e interface{}) (string, error) {
+	if e == nil {
+		return "", ErrInvalidKeyElements
+	}
+
+	if f.options.KeyEncoder != nil {
+		return f.options.KeyEncoder(e)
+	}
+
+	if k, ok := e.(CacheKeyer); ok {
+		if v := reflect.ValueOf(e); v.Kind() != reflect.Ptr || !v.IsNil() {
+			return k.CacheKey(), nil
+		}
+	}
+
+	if t, ok := e.(time.Time); ok {
+		return f.formatTime(t), nil
+	}
 
-		case reflect.Array, reflect.Slice:
-			var il []interface{}
-			for i := 0; i < v.Len(); i++ {
-				il = append(il, v.Index(i).Interface())
+	v := reflect.ValueOf(e)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", ErrInvalidKeyElements
+		}
+		if _, ok := v.Elem().Interface().(encoding.TextMarshaler); !ok {
+			if m, ok := e.(encoding.TextMarshaler); ok {
+				return marshalText(m) // pointer receiver.
 			}
+		}
+		return f.encodeElement(v.Elem().Interface())
+	}
+
+	if m, ok := e.(encoding.TextMarshaler); ok {
+		return marshalText(m)
+	}
+
+	switch v.Kind() {
+
+	case reflect.Array, reflect.Slice:
+		var il []interface{}
+		for i := 0; i < v.Len(); i++ {
+			il = append(il, v.Index(i).Interface())
+		}
+		if f.options.UnambiguousCollections {
+			return f.encodeCollection(il)
+		}
+		return f.toStringsForElements(il...)
+
+	case reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Interface, reflect.Invalid:
+		return "", ErrInvalidKeyElements
+	}
+
+	if s, ok := e.(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Complex64:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 64), nil
+	case reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 128), nil
+	case reflect.Struct:
+		return f.encodeStruct(v)
+	}
 
-			if e, err = f.toStringsForElements(il...); err != nil {
-				return "", err
+	return "", ErrInvalidKeyElements
+}
+
+func marshalText(m encoding.TextMarshaler) (string, error) {
+	b, err := m.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// encodeStruct encodes struct without String() to "<name>:<value>" of the exported fields.
+// The name follows Options.KeyStructTag, and the field tagged "-" is skipped.
+func (f *cacheFetcherImpl) encodeStruct(v reflect.Value) (string, error) {
+	var el []string
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported.
+		}
+
+		name, opts := sf.Name, ""
+		if tag, ok := sf.Tag.Lookup(f.options.KeyStructTag); ok {
+			if tag == "-" {
+				continue
 			}
 
-		case reflect.Struct:
-			if _, ok := e.(interface{ String() string }); !ok {
-				return "", ErrInvalidKeyElements
+			n := tag
+			if i := strings.Index(tag, ","); i >= 0 {
+				n, opts = tag[:i], tag[i:]
+			}
+			if n != "" {
+				name = n
 			}
+		}
 
-		case reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Interface, reflect.Invalid:
-			return "", ErrInvalidKeyElements
+		if f.options.KeyStructOmitEmpty && strings.Contains(opts, ",omitempty") && v.Field(i).IsZero() {
+			continue
 		}
 
-		el = append(el, fmt.Sprintf("%+v", e))
+		s, err := f.encodeElement(v.Field(i).Interface())
+		if err != nil {
+			return "", err
+		}
+		el = append(el, name+":"+s)
 	}
 
 	return strings.Join(el, sep), nil
 }
 
+func (f *cacheFetcherImpl) formatTime(t time.Time) string {
+	switch f.options.TimeKeyLayout {
+	case "":
+		return t.Format(defaultTimeKeyLayout)
+	case TimeKeyLayoutUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format(f.options.TimeKeyLayout)
+}
+
 // Fetch function or cache.
+// fetcher is func() (T, error) or func(context.Context) (T, error) called with context.Background().
 func (f *cacheFetcherImpl) Fetch(expiration time.Duration, dst interface{}, fetcher interface{}) error {
+	return f.FetchWithContext(context.Background(), expiration, dst, fetcher)
+}
+
+// FetchWithContext is Fetch that passes ctx to func(context.Context) (T, error) fetcher.
+// With singleflight, the concurrent callers share the first caller's ctx.
+func (f *cacheFetcherImpl) FetchWithContext(ctx context.Context, expiration time.Duration, dst interface{}, fetcher interface{}) error {
+	start := f.options.Clock.Now()
+	if err := validateFetcher(dst, fetcher); err != nil {
+		return err
+	}
+	if f.options.BypassFunc != nil && f.options.BypassFunc(ctx) {
+		if err := f.bypass(ctx, expiration, dst, fetcher); err != nil {
+			return f.debugPrintErr(err, start)
+		}
+		return f.debugPrint(result{}, start)
+	}
+	if f.getWithoutGroup(dst) {
+		f.refreshTTL(expiration)
+		return f.debugPrint(result{}, start)
+	}
+
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
+
 	select {
-	case res := <-f.options.Group.DoChan(f.key, f.fetch(expiration, dst, fetcher)):
+	case res := <-f.doChanWithKey(ctx, f.flightKey(), f.fetch(ctx, expiration, dst, fetcher)):
 		if res.Err != nil {
-			return res.Err
+			return f.debugPrintErr(res.Err, start)
+		}
+		if err := setDst(dst, res.Val); err != nil {
+			return err
 		}
-		reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(res.Val))
 
-		if err := f.debugPrint(res.Shared); err != nil {
+		if err := f.debugPrint(res, start); err != nil {
 			return err
 		}
 
 		return nil
 
-	case <-time.After(f.options.GroupTimeout):
-		return ErrTimeout
+	case <-timeout:
+		return f.debugPrintErr(ErrTimeout, start)
 	}
 }
 
-func (f *cacheFetcherImpl) fetch(expiration time.Duration, dst interface{}, fetcher interface{}) func() (interface{}, error) {
-	return func() (interface{}, error) {
-		_, err := f.get(dst, false)()
-		if f.isErrOtherThanCacheMiss(err) {
-			return nil, err
-		}
-
-		if f.isCached {
-			return reflect.ValueOf(dst).Elem().Interface(), nil
-		}
+// bypass calls fetcher without reading the cache, and refreshes the cache with the result.
+// It does not use singleflight, so that the bypass does not share the cached value with the other callers.
+func (f *cacheFetcherImpl) bypass(ctx context.Context, expiration time.Duration, dst interface{}, fetcher interface{}) error {
+	v, err := f.callFetcher(ctx, expiration, fetcher)
+	f.setCached(false)
+	if err != nil {
+		return err
+	}
+	return setDst(dst, v)
+}
 
-		// fetch function
-		v := reflect.ValueOf(fetcher).Call(nil)
-		if !v[1].IsNil() {
-			return nil, v[1].Interface().(error)
-		}
+// FetchInto is Fetch that appends the elements to the slice dst, instead of overwriting dst.
+// dst must be a pointer to slice, e.g. a preallocated buffer.
+func (f *cacheFetcherImpl) FetchInto(expiration time.Duration, dst interface{}, fetcher interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dst: %w", ErrNoSliceType)
+	}
 
-		fRes := v[0].Interface()
-		if reflect.TypeOf(fRes).Kind() == reflect.Ptr {
-			fRes = reflect.ValueOf(fRes).Elem().Interface()
-		}
+	v := reflect.New(dv.Elem().Type())
+	if err := f.Fetch(expiration, v.Interface(), fetcher); err != nil {
+		return err
+	}
 
-		isCached := f.isCached
-		if err := f.set(fRes, expiration, false); err != nil {
-			return nil, err
-		}
-		f.isCached = isCached // replace get's isCached
+	dv.Elem().Set(reflect.AppendSlice(dv.Elem(), v.Elem()))
+	return nil
+}
 
-		return fRes, nil
+func (f *cacheFetcherImpl) fetch(ctx context.Context, expiration time.Duration, dst interface{}, fetcher interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		v, _, err := f.getOrCallFetcher(ctx, expiration, dst, fetcher)
+		return v, err
 	}
 }
 
-// Set cache.
-func (f *cacheFetcherImpl) Set(value interface{}, expiration time.Duration) error {
-	if err := f.set(value, expiration, false); err != nil {
-		return err
+// validateFetcher checks fetcher's result type is assignable to dst before calling.
+// An interface result is checked after calling by setDst.
+func validateFetcher(dst interface{}, fetcher interface{}) error {
+	dt := reflect.TypeOf(dst)
+	if dt == nil || dt.Kind() != reflect.Ptr {
+		return fmt.Errorf("dst: %w", ErrNoPointerType)
 	}
 
-	if err := f.debugPrint(false); err != nil {
-		return err
+	ft := reflect.TypeOf(fetcher)
+	if ft == nil || ft.Kind() != reflect.Func || ft.NumOut() != 2 {
+		return fmt.Errorf("%w: fetcher is %v", ErrFetchTypeMismatch, ft)
+	}
+
+	out := ft.Out(0)
+	for out.Kind() == reflect.Ptr {
+		out = out.Elem()
+	}
+	if out.Kind() != reflect.Interface && !out.AssignableTo(dt.Elem()) {
+		return fmt.Errorf("%w: %v is not assignable to %v", ErrFetchTypeMismatch, out, dt.Elem())
 	}
 	return nil
 }
 
-// Set cache.
-func (f *cacheFetcherImpl) SetString(value string, expiration time.Duration) error {
-	if err := f.set(value, expiration, true); err != nil {
-		return err
+// setDst sets v to dst without panic.
+func setDst(dst interface{}, v interface{}) error {
+	e := reflect.ValueOf(dst).Elem()
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || !rv.Type().AssignableTo(e.Type()) {
+		return fmt.Errorf("%w: %T is not assignable to %v", ErrFetchTypeMismatch, v, e.Type())
 	}
 
-	if err := f.debugPrint(false); err != nil {
-		return err
-	}
+	e.Set(rv)
 	return nil
 }
 
-func (f *cacheFetcherImpl) set(value interface{}, expiration time.Duration, isStringMode bool) error {
-	f.isCached = false
-	v := value
-	if !(isStringMode || f.options.IsNotSerialized) {
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(value); err != nil {
-			return fmt.Errorf("%w: %+v", ErrGobSerialized, err)
+// getOrCallFetcher gets cache, or calls fetcher function if cache miss.
+// fromCache reports whether the value is from cache.
+func (f *cacheFetcherImpl) getOrCallFetcher(ctx context.Context, expiration time.Duration, dst interface{}, fetcher interface{}) (interface{}, bool, error) {
+	v, err := f.get(dst, false)()
+	if f.isErrOnFetch(err) {
+		if !f.isFallbackToFetcher(err) {
+			return nil, false, err
 		}
 
-		v = buf.String()
+		v, err = f.callFetcherWithoutCache(ctx, fetcher)
+		return v, false, err
 	}
 
-	if err := f.client.Set(f.key, v, expiration); err != nil {
-		return err
+	if err == nil {
+		f.refreshTTL(expiration)
+		return v, true, nil
 	}
 
-	f.isCached = true
-	return nil
+	v, err = f.callFetcher(ctx, expiration, fetcher)
+	return v, false, err
 }
 
-// Get cache as any interface.
-func (f *cacheFetcherImpl) Get(dst interface{}) error {
-	select {
-	case res := <-f.options.Group.DoChan(f.key, f.get(dst, false)):
-		if res.Err != nil {
-			return res.Err
-		}
-		reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(res.Val))
+// GetOrSet is Fetch that returns whether the value is from cache.
+// fromCache is tied to this call, not the fetcher's state like IsCached.
+func (f *cacheFetcherImpl) GetOrSet(expiration time.Duration, dst interface{}, fetcher interface{}) (bool, error) {
+	start := f.options.Clock.Now()
+	if err := validateFetcher(dst, fetcher); err != nil {
+		return false, err
+	}
 
-		if err := f.debugPrint(res.Shared); err != nil {
-			return err
-		}
-		return nil
+	type result struct {
+		val       interface{}
+		fromCache bool
+	}
 
-	case <-time.After(f.options.GroupTimeout):
-		return ErrTimeout
+	// the result type differs from Fetch, so singleflight's key is separated.
+	fn := func() (interface{}, error) {
+		v, fromCache, err := f.getOrCallFetcher(context.Background(), expiration, dst, fetcher)
+		if err != nil {
+			return nil, err
+		}
+		return result{val: v, fromCache: fromCache}, nil
 	}
-}
 
-// Get cache as string.
-func (f *cacheFetcherImpl) GetString() (string, error) {
-	var dst string
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
 
 	select {
-	case res := <-f.options.Group.DoChan(f.key, f.get(&dst, true)):
+	case res := <-f.doChanWithKey(context.Background(), f.flightKey()+sep+getOrSetSuffix, fn):
 		if res.Err != nil {
-			return "", res.Err
+			return false, f.debugPrintErr(res.Err, start)
+		}
+		r := res.Val.(result)
+		if err := setDst(dst, r.val); err != nil {
+			return false, err
 		}
 
-		if err := f.debugPrint(res.Shared); err != nil {
-			return "", err
+		if err := f.debugPrint(res, start); err != nil {
+			return false, err
 		}
-		return res.Val.(string), nil
+		return r.fromCache, nil
 
-	case <-time.After(f.options.GroupTimeout):
-		return "", ErrTimeout
+	case <-timeout:
+		return false, f.debugPrintErr(ErrTimeout, start)
 	}
 }
 
-func (f *cacheFetcherImpl) get(dst interface{}, isStringMode bool) func() (interface{}, error) {
-	return func() (interface{}, error) {
-		f.isCached = false
+// callFetcher calls fetcher function and sets the result to cache.
+func (f *cacheFetcherImpl) callFetcher(ctx context.Context, expiration time.Duration, fetcher interface{}) (interface{}, error) {
+	fRes, skipCache, err := f.callFetcherFunc(ctx, fetcher)
+	if err != nil || skipCache {
+		return fRes, err
+	}
 
-		if reflect.TypeOf(dst).Kind() != reflect.Ptr {
-			return nil, fmt.Errorf("dst: %w", ErrNoPointerType)
-		}
+	isCached := f.cached()
+	if err := f.set(fRes, expiration, f.options.RawStringFetch && isRawValue(fRes)); err != nil && !f.isFallbackToFetcher(err) {
+		return nil, err
+	}
+	f.setCached(isCached) // replace get's isCached
 
-		var s string
-		if err := f.client.Get(f.key, &s); err != nil {
-			return nil, err
-		}
+	return fRes, nil
+}
 
-		if isStringMode || f.options.IsNotSerialized {
-			reflect.ValueOf(dst).Elem().SetString(s)
-		} else {
-			buf := bytes.NewBufferString(s)
-			if err := gob.NewDecoder(buf).Decode(dst); err != nil {
-				return nil, fmt.Errorf("%w: %+v", ErrGobSerialized, err)
+// callFetcherWithoutCache calls fetcher function without setting cache.
+func (f *cacheFetcherImpl) callFetcherWithoutCache(ctx context.Context, fetcher interface{}) (interface{}, error) {
+	fRes, _, err := f.callFetcherFunc(ctx, fetcher)
+	return fRes, err
+}
+
+// callFetcherFunc calls fetcher function. skipCache reports the fetcher returns SkipCache.
+func (f *cacheFetcherImpl) callFetcherFunc(ctx context.Context, fetcher interface{}) (_ interface{}, _ bool, err error) {
+	defer func() {
+		// the panic in the singleflight goroutine crashes the process, so it is the error of this call.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\n%s", ErrFetcherPanic, r, debug.Stack())
+		}
+	}()
+
+	var in []reflect.Value
+	if t := reflect.TypeOf(fetcher); t.NumIn() == 1 && t.In(0) == contextType {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+
+	v := reflect.ValueOf(fetcher).Call(in)
+	skipCache := false
+	if !v[1].IsNil() {
+		err := v[1].Interface().(error)
+		if !errors.Is(err, SkipCache) {
+			return nil, false, err
+		}
+		skipCache = true
+	}
+
+	rv := v[0]
+	if rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false, ErrNilFetchResult
+		}
+		rv = rv.Elem()
+	}
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false, ErrNilFetchResult
+		}
+		rv = rv.Elem() // pointer to pointer is dereferenced to the value.
+	}
+	return rv.Interface(), skipCache, nil
+}
+
+// LockedFetch is Fetch with a distributed lock to prevent cross-process stampedes.
+// The process that acquires the lock "<key>_lock" with SetNX calls fetcher.
+// The other processes poll the cache until lockTTL, and then call fetcher directly.
+func (f *cacheFetcherImpl) LockedFetch(expiration, lockTTL time.Duration, dst interface{}, fetcher interface{}) error {
+	start := f.options.Clock.Now()
+	if err := validateFetcher(dst, fetcher); err != nil {
+		return err
+	}
+	if f.getWithoutGroup(dst) {
+		return f.debugPrint(result{}, start)
+	}
+
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
+
+	select {
+	case res := <-f.doChan(f.lockedFetch(expiration, lockTTL, dst, fetcher)):
+		if res.Err != nil {
+			return f.debugPrintErr(res.Err, start)
+		}
+		if err := setDst(dst, res.Val); err != nil {
+			return err
+		}
+
+		if err := f.debugPrint(res, start); err != nil {
+			return err
+		}
+
+		return nil
+
+	case <-timeout:
+		return f.debugPrintErr(ErrTimeout, start)
+	}
+}
+
+func (f *cacheFetcherImpl) lockedFetch(expiration, lockTTL time.Duration, dst interface{}, fetcher interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		_, err := f.get(dst, false)()
+		if f.isErrOnFetch(err) {
+			return nil, err
+		}
+
+		if f.cached() {
+			return reflect.ValueOf(dst).Elem().Interface(), nil
+		}
+
+		lockKey := f.key + reservedSep + lockSuffix
+		token, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		err = f.withClientTimeout(func() (err error) {
+			ok, err = f.client.SetNX(lockKey, token, lockTTL)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			defer f.unlock(lockKey, token)
+			return f.callFetcher(context.Background(), expiration, fetcher)
+		}
+
+		// wait for the lock winner's result.
+		for deadline := f.options.Clock.Now().Add(lockTTL); f.options.Clock.Now().Before(deadline); {
+			<-f.options.Clock.After(lockPollInterval)
+
+			_, err := f.get(dst, false)()
+			if f.isErrOnFetch(err) {
+				return nil, err
 			}
+
+			if f.cached() {
+				return reflect.ValueOf(dst).Elem().Interface(), nil
+			}
+		}
+
+		return f.callFetcher(context.Background(), expiration, fetcher)
+	}
+}
+
+// unlock releases the lock of LockedFetch only if it still has token, so that the lock taken by the other process
+// after lockTTL is not released. It is atomic with CompareAndDeleter, and Get and Del without it.
+func (f *cacheFetcherImpl) unlock(lockKey, token string) {
+	_ = f.withClientTimeout(func() error {
+		if c, ok := f.client.(CompareAndDeleter); ok {
+			_, err := c.DelIfEquals(lockKey, token)
+			return err
+		}
+
+		var s string
+		if err := f.client.Get(lockKey, &s); err != nil || s != token {
+			return err
+		}
+		return f.client.Del(lockKey)
+	})
+}
+
+// Set cache.
+func (f *cacheFetcherImpl) Set(value interface{}, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.set(value, expiration, false); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Set cache.
+func (f *cacheFetcherImpl) SetString(value string, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.set(value, expiration, true); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Set cache as raw bytes without serialization.
+// The bytes are not gob-decodable via Get except into *[]byte, use GetBytes.
+func (f *cacheFetcherImpl) SetBytes(b []byte, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.set(b, expiration, true); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) set(value interface{}, expiration time.Duration, isStringMode bool) error {
+	f.setCached(false)
+	expiration, err := f.resolveExpiration(expiration)
+	if err != nil {
+		return err
+	}
+	v, skip, err := f.storedValue(f.key, value, isStringMode)
+	if err != nil || skip {
+		return err // the skipped value is not cached.
+	}
+
+	if f.options.AsyncWrite {
+		if err := f.checkKey(); err != nil {
+			return err
+		}
+		f.setAsync(v, value, expiration)
+		f.setCached(true) // scheduled.
+		return nil
+	}
+
+	if err := f.withClientTimeout(func() error { return f.client.Set(f.key, v, expiration) }); err != nil {
+		return err
+	}
+
+	f.setCached(true)
+	f.setDone(f.key, value)
+	return nil
+}
+
+// storedValue returns the stored value of value for key: encoded, stamped with the meta, and checked with Options.MaxValueBytes.
+// skip is true for the oversized value with Options.SkipOversized. The error is wrapped with key.
+func (f *cacheFetcherImpl) storedValue(key string, value interface{}, isStringMode bool) (v interface{}, skip bool, err error) {
+	v, err = f.encode(value, isStringMode)
+	if err != nil {
+		return nil, false, f.withGivenKey(key, err)
+	}
+	if f.withHeader(isStringMode) {
+		v = f.stampMeta(v.(string))
+	}
+	if n := storedSize(v); f.options.MaxValueBytes > 0 && n > f.options.MaxValueBytes {
+		if f.options.SkipOversized {
+			return nil, true, nil
+		}
+		return nil, false, f.withGivenKey(key, fmt.Errorf("%w: %d bytes, max %d", ErrValueTooLarge, n, f.options.MaxValueBytes))
+	}
+	return v, false, nil
+}
+
+// resolveExpiration returns Options.DefaultExpiration for UseDefault, and ErrInvalidExpiration for the other negative expiration.
+// The positive expiration is added Options.TTLJitter's random duration.
+func (f *cacheFetcherImpl) resolveExpiration(expiration time.Duration) (time.Duration, error) {
+	if expiration == UseDefault {
+		expiration = f.options.DefaultExpiration
+	}
+	if expiration < 0 {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidExpiration, expiration)
+	}
+	if expiration > 0 && f.options.TTLJitter > 0 {
+		expiration += time.Duration(rand.Int63n(int64(f.options.TTLJitter)))
+	}
+	return expiration, nil
+}
+
+// setDone counts the stored value, and calls Options.OnSet.
+func (f *cacheFetcherImpl) setDone(key string, value interface{}) {
+	f.options.stats.countSet()
+	if f.options.OnSet != nil {
+		f.options.OnSet(key, value)
+	}
+}
+
+// storedSize returns the bytes of the encoded value. The value not serialized, e.g. int with IsNotSerialized, is 0.
+func storedSize(v interface{}) int {
+	switch b := v.(type) {
+	case string:
+		return len(b)
+	case []byte:
+		return len(b)
+	}
+	return 0
+}
+
+// encode returns the stored value of value. It is serialized by Options.Serializer and compressed by the options.
+// encoding.BinaryMarshaler's value is serialized by MarshalBinary.
+func (f *cacheFetcherImpl) encode(value interface{}, isStringMode bool) (interface{}, error) {
+	if f.withHeader(isStringMode) {
+		return f.encodeWithHeader(value)
+	}
+
+	v := value
+	if !(isStringMode || f.options.IsNotSerialized || isRawValue(value)) {
+		b, err := f.serializer(value).Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		v = string(b)
+	}
+
+	if !isStringMode && f.options.Compression != nil {
+		var err error
+		switch b := v.(type) {
+		case string:
+			v, err = compress(f.options.Compression, []byte(b))
+		case []byte:
+			v, err = compress(f.options.Compression, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// withHeader reports whether the value is stored with the format header.
+// The metadata of Options.StoreMeta and Options.RefreshAhead is stored in it.
+func (f *cacheFetcherImpl) withHeader(isStringMode bool) bool {
+	return (f.options.FormatHeader || f.storesMeta()) && !(isStringMode || f.options.IsNotSerialized)
+}
+
+// isRawValue reports whether value is stored without gob.
+// string and []byte values are stored raw and decoded raw into *string or *[]byte dst.
+func isRawValue(value interface{}) bool {
+	switch value.(type) {
+	case string, []byte:
+		return true
+	}
+	return false
+}
+
+// Get cache as any interface.
+// dst must be a pointer to a concrete type. A pointer to interface{} returns ErrInterfaceType,
+// because gob has no concrete type to decode into.
+func (f *cacheFetcherImpl) Get(dst interface{}) error {
+	start := f.options.Clock.Now()
+	if f.getWithoutGroup(dst) {
+		return f.debugPrint(result{}, start)
+	}
+
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
+
+	select {
+	case res := <-f.doChan(f.get(dst, false)):
+		if res.Err != nil {
+			return f.debugPrintErr(res.Err, start)
+		}
+		if err := setDst(dst, res.Val); err != nil {
+			return err
+		}
+
+		if err := f.debugPrint(res, start); err != nil {
+			return err
+		}
+		return nil
+
+	case <-timeout:
+		return f.debugPrintErr(ErrTimeout, start)
+	}
+}
+
+// GetMany gets the caches of keys with one MGet of MultiGetter, or Get by key. keys are the composed keys, e.g. Key()'s result.
+// Each value is decoded into a fresh dst of newDst, and the result maps the key to the dst.
+// The missing keys are omitted from the result, not error.
+func (f *cacheFetcherImpl) GetMany(keys []string, newDst func(key string) interface{}) (map[string]interface{}, error) {
+	start := f.options.Clock.Now()
+	res, err := f.getMany(keys, newDst)
+	if err != nil {
+		return nil, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (f *cacheFetcherImpl) getMany(keys []string, newDst func(key string) interface{}) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	if len(keys) == 0 {
+		return res, nil
+	}
+
+	var values map[string]string
+	err := f.withKeysClientTimeout(func() (err error) {
+		values, err = f.mget(keys)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for k, s := range values {
+		dst := newDst(k)
+		if err := f.checkDst(dst); err != nil {
+			return nil, err
+		}
+
+		if err := f.decode(s, dst, false); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
 		}
+		res[k] = dst
+	}
+	return res, nil
+}
+
+// Get cache as string.
+func (f *cacheFetcherImpl) GetString() (string, error) {
+	start := f.options.Clock.Now()
+
+	var dst string
 
-		f.isCached = true
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
+
+	select {
+	case res := <-f.doChan(f.get(&dst, true)):
+		if res.Err != nil {
+			return "", f.debugPrintErr(res.Err, start)
+		}
+
+		if err := f.debugPrint(res, start); err != nil {
+			return "", err
+		}
+		return res.Val.(string), nil
+
+	case <-timeout:
+		return "", f.debugPrintErr(ErrTimeout, start)
+	}
+}
+
+// Get cache as raw bytes without deserialization.
+// It does not share the call with GetString, because the result type differs.
+func (f *cacheFetcherImpl) GetBytes() ([]byte, error) {
+	start := f.options.Clock.Now()
+
+	var dst []byte
+
+	timeout, stop := f.options.Clock.Timer(f.options.GroupTimeout)
+	defer stop()
+
+	select {
+	case res := <-f.doChanWithKey(context.Background(), f.key+sep+getBytesSuffix, f.get(&dst, true)):
+		if res.Err != nil {
+			return nil, f.debugPrintErr(res.Err, start)
+		}
+
+		if err := f.debugPrint(res, start); err != nil {
+			return nil, err
+		}
+		return res.Val.([]byte), nil
+
+	case <-timeout:
+		return nil, f.debugPrintErr(ErrTimeout, start)
+	}
+}
+
+func (f *cacheFetcherImpl) get(dst interface{}, isStringMode bool) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		f.setCached(false)
+		f.setTier("")
+		f.setWrittenAt(time.Time{})
+
+		if err := f.checkDst(dst); err != nil {
+			return nil, err
+		}
+
+		var s string
+		if err := f.withClientTimeout(func() error { return f.clientGet(&s) }); err != nil {
+			return nil, err
+		}
+
+		if !isStringMode {
+			f.setWrittenAt(metaOf(s).WrittenAt)
+		}
+		if err := f.decode(s, dst, isStringMode); err != nil {
+			return nil, f.withKey(err)
+		}
+
+		f.setCached(true)
 		return reflect.ValueOf(dst).Elem().Interface(), nil
 	}
 }
 
+// getWithoutGroup is the fast path of SkipSingleflightOnHit. It gets the cache without Group,
+// and reports whether it is hit. The hit can not stampede, so only the miss and the error go through Group.
+func (f *cacheFetcherImpl) getWithoutGroup(dst interface{}) bool {
+	if !f.options.SkipSingleflightOnHit || f.options.DisableSingleflight {
+		return false
+	}
+
+	_, err := f.get(dst, false)()
+	return err == nil
+}
+
+// checkDst checks dst is a pointer to a concrete type, and registers it to gob.
+func (f *cacheFetcherImpl) checkDst(dst interface{}) error {
+	if reflect.TypeOf(dst).Kind() != reflect.Ptr {
+		return fmt.Errorf("dst: %w", ErrNoPointerType)
+	}
+
+	if reflect.TypeOf(dst).Elem().Kind() == reflect.Interface {
+		return fmt.Errorf("dst: %w", ErrInterfaceType)
+	}
+
+	f.autoGobRegister(dst)
+	return nil
+}
+
+// decode decodes the stored value s into dst.
+// The value without the format header is decompressed only with Options.Compression.
+func (f *cacheFetcherImpl) decode(s string, dst interface{}, isStringMode bool) error {
+	if !(isStringMode || f.options.IsNotSerialized) && hasFormatHeader(s) {
+		return f.decodeWithHeader(s, dst)
+	}
+
+	if !isStringMode && f.options.Compression != nil {
+		var err error
+		if s, err = decompress(s); err != nil {
+			return err
+		}
+	}
+
+	switch d := dst.(type) {
+	case *string:
+		*d = s // string is stored raw.
+
+	case *[]byte:
+		*d = []byte(s) // []byte is stored raw.
+
+	default:
+		if isStringMode || f.options.IsNotSerialized {
+			reflect.ValueOf(dst).Elem().SetString(s)
+			break
+		}
+
+		var ser Serializer = &GobSerializer{} // the value without the header is legacy gob.
+		if ts, ok := f.typeSerializer(reflect.TypeOf(dst)); ok && !f.options.FormatHeader {
+			ser = ts
+		} else if _, ok := dst.(encoding.BinaryUnmarshaler); ok {
+			ser = &binarySerializer{}
+		} else if !f.options.FormatHeader {
+			ser = f.options.Serializer
+		}
+		return f.unmarshal(ser, []byte(s), dst)
+	}
+	return nil
+}
+
+// mget gets keys with MultiGetter, or with Get one by one. The missing keys are omitted.
+func (f *cacheFetcherImpl) mget(keys []string) (map[string]string, error) {
+	return getMulti(f.client, keys, f.isErrCacheMiss)
+}
+
+// getMulti gets keys of c with MultiGetter, or with Get one by one. The keys of isMiss's error are omitted.
+func getMulti(c Client, keys []string, isMiss func(error) bool) (map[string]string, error) {
+	if m, ok := c.(MultiGetter); ok {
+		return m.MGet(keys)
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		var s string
+		err := c.Get(k, &s)
+		switch {
+		case err == nil:
+			values[k] = s
+		case !isMiss(err):
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// clientGet gets the stored value. It records the tier if the client is TieredClient.
+func (f *cacheFetcherImpl) clientGet(s *string) error {
+	c, ok := f.client.(TieredClient)
+	if !ok {
+		return f.client.Get(f.key, s)
+	}
+
+	tier, err := c.GetWithTier(f.key, s)
+	f.setTier(tier)
+	return err
+}
+
 // Delete cache.
+// IsCached is false after Del, because the value no longer exists.
 func (f *cacheFetcherImpl) Del() error {
-	err := f.client.Del(f.key)
-	f.isCached = true
-	if f.client.IsErrCacheMiss(err) {
-		f.isCached = false
-	}
+	start := f.options.Clock.Now()
+	err := f.withClientTimeout(func() error { return f.client.Del(f.key) })
+	f.setCached(false)
 	if err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
 		return err
 	}
+	return nil
+}
+
+// DelKeys deletes the caches of keys at once. keys are the composed keys, e.g. Key()'s result.
+// The missing keys are not error.
+func (f *cacheFetcherImpl) DelKeys(keys []string) error {
+	start := f.options.Clock.Now()
+	if len(keys) == 0 {
+		return nil
+	}
 
-	if err := f.debugPrint(false); err != nil {
+	err := f.withKeysClientTimeout(func() error {
+		_, err := f.client.DelMulti(keys)
+		return err
+	})
+	if f.isErrOtherThanCacheMiss(err) {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
 		return err
 	}
 	return nil
 }
 
+// DelIfEquals deletes cache only if the stored value equals expected, and returns whether it is deleted.
+// expected is encoded as Set does, so the encoding must be deterministic. e.g. gob encodes map in random order.
+// The metadata of Options.StoreMeta and Options.RefreshAhead is not compared.
+// The client must implement CompareAndDeleter.
+func (f *cacheFetcherImpl) DelIfEquals(expected interface{}) (bool, error) {
+	start := f.options.Clock.Now()
+	c, ok := f.client.(CompareAndDeleter)
+	if !ok {
+		return false, f.debugPrintErr(ErrNotCompareAndDeleter, start)
+	}
+
+	v, err := f.encode(expected, false)
+	if err != nil {
+		return false, f.debugPrintErr(err, start)
+	}
+
+	var deleted bool
+	err = f.withClientTimeout(func() (err error) {
+		deleted, err = f.delIfEquals(c, v)
+		return err
+	})
+	if err != nil {
+		return false, f.debugPrintErr(err, start)
+	}
+	f.setCached(deleted)
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// delIfEquals deletes the key if the stored value equals v. The stored value with the metadata is got and compared without it,
+// and it is deleted only if it is not changed since it is got.
+func (f *cacheFetcherImpl) delIfEquals(c CompareAndDeleter, v interface{}) (bool, error) {
+	if !f.storesMeta() || !f.withHeader(false) {
+		return c.DelIfEquals(f.key, v)
+	}
+
+	var s string
+	if err := f.clientGet(&s); err != nil {
+		if f.isErrCacheMiss(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if stripMeta(s) != v.(string) {
+		return false, nil
+	}
+	return c.DelIfEquals(f.key, s)
+}
+
+// Exists checks the key is cached without deserializing the value.
+// A miss returns false and nil error.
+func (f *cacheFetcherImpl) Exists() (bool, error) {
+	start := f.options.Clock.Now()
+
+	var ok bool
+	err := f.withClientTimeout(func() (err error) {
+		ok, err = f.client.Exists(f.key)
+		return err
+	})
+	if f.isErrOtherThanCacheMiss(err) {
+		return false, f.debugPrintErr(err, start)
+	}
+	f.setCached(ok)
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// PeekRaw gets the on-wire stored value, e.g. gob and compressed bytes, and whether it is present,
+// bypassing singleflight and deserialization. It is for the admin and debug endpoints, not the hot paths.
+// A miss returns false and nil error.
+func (f *cacheFetcherImpl) PeekRaw() (string, bool, error) {
+	start := f.options.Clock.Now()
+	f.setCached(false)
+
+	var s string
+	err := f.withClientTimeout(func() error { return f.clientGet(&s) })
+	if f.isErrOtherThanCacheMiss(err) {
+		return "", false, f.debugPrintErr(err, start)
+	}
+	f.setCached(err == nil)
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return "", false, err
+	}
+	return s, f.cached(), nil
+}
+
+// Scan lists the cached keys that start with prefix. Options.KeyPrefix is prepended to prefix.
+// It is backed by SCAN, so it is eventually-consistent against a live keyspace and not suitable for exact counting.
+func (f *cacheFetcherImpl) Scan(prefix string) ([]string, error) {
+	if f.options.KeyPrefix != "" {
+		prefix = f.options.KeyPrefix + sep + prefix
+	}
+	var keys []string
+	err := f.withKeysClientTimeout(func() (err error) {
+		keys, err = f.client.Scan(globEscaper.Replace(prefix) + "*")
+		return err
+	})
+	return keys, err
+}
+
 // GobRegister is register gob.
+// Registration is process-global, so it affects every fetcher in the process.
+// Register the concrete types held by interface fields before Set or Get.
 func (f *cacheFetcherImpl) GobRegister(value interface{}) {
 	gob.Register(value)
 }
 
-// Get cached.
+// autoGobRegister registers dst's element type.
+// gob.Register panics on conflicting names, so it is recovered and ignored.
+func (f *cacheFetcherImpl) autoGobRegister(dst interface{}) {
+	e := reflect.ValueOf(dst).Elem()
+	if e.Kind() == reflect.Interface {
+		return // no concrete type.
+	}
+
+	gobRegister(reflect.Zero(e.Type()).Interface())
+}
+
+// gobRegister registers value to gob. It ignores the panic of the conflicting registration,
+// so that the registration is idempotent.
+func gobRegister(value interface{}) {
+	defer func() { _ = recover() }()
+	gob.Register(value)
+}
+
+// IsCached reports whether the last operation used the cache.
+// It is safe for the concurrent use, but the last operation is of any goroutine sharing the fetcher.
+// Use GetOrSet's fromCache for the result of the specific call.
 func (f *cacheFetcherImpl) IsCached() bool {
+	return f.cached()
+}
+
+func (f *cacheFetcherImpl) cached() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	return f.isCached
 }
 
+func (f *cacheFetcherImpl) setCached(isCached bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.isCached = isCached
+}
+
+func (f *cacheFetcherImpl) setTier(tier Tier) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tier = tier
+}
+
+// takeTier returns the tier and resets it, because the tier is per operation.
+func (f *cacheFetcherImpl) takeTier() Tier {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tier := f.tier
+	f.tier = ""
+	return tier
+}
+
+func (f *cacheFetcherImpl) lastWrittenAt() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writtenAt
+}
+
+func (f *cacheFetcherImpl) setWrittenAt(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writtenAt = t
+}
+
+// Clone returns a new fetcher with the same client and options, and the empty key.
+// The clone shares the singleflight group with the original.
+func (f *cacheFetcherImpl) Clone() CacheFetcher {
+	return &cacheFetcherImpl{
+		client:  f.client,
+		options: f.options,
+	}
+}
+
+// doChan calls fn with singleflight, or directly if DisableSingleflight.
+// If fn returns error, the key is forgotten so that the next caller re-attempts.
+func (f *cacheFetcherImpl) doChan(fn func() (interface{}, error)) <-chan result {
+	return f.doChanWithKey(context.Background(), f.key, fn)
+}
+
+func (f *cacheFetcherImpl) doChanWithKey(ctx context.Context, key string, fn func() (interface{}, error)) <-chan result {
+	ch := make(chan result, 1)
+	key = f.groupKey(ctx, key)
+	atomic.AddInt32(&f.inFlight, 1)
+
+	if f.options.DisableSingleflight {
+		go func() {
+			v, err := fn()
+			atomic.AddInt32(&f.inFlight, -1)
+			ch <- result{Result: singleflight.Result{Val: v, Err: err}, Waiters: 1}
+		}()
+		return ch
+	}
+
+	wk := waiterKey{group: f.options.Group, key: key}
+	waiters.add(wk, 1)
+
+	src := f.options.Group.DoChan(key, func() (interface{}, error) {
+		v, err := fn()
+		if err != nil && !f.options.DisableForgetOnError {
+			f.options.Group.Forget(key)
+		}
+		return v, err
+	})
+
+	go func() {
+		res := <-src
+		atomic.AddInt32(&f.inFlight, -1)
+		ch <- result{Result: res, Waiters: waiters.add(wk, -1) + 1}
+	}()
+	return ch
+}
+
+// idle reports whether no background work uses the fetcher.
+func (f *cacheFetcherImpl) idle() bool {
+	return atomic.LoadInt32(&f.inFlight) == 0
+}
+
+// groupKey returns the singleflight key that is scoped by Options.GroupKeyPrefix and Options.GroupKeyFromContext.
+// The scopes are joined with NUL not to collide with the separator in the key.
+func (f *cacheFetcherImpl) groupKey(ctx context.Context, key string) string {
+	if f.options.GroupKeyFromContext != nil {
+		key = f.options.GroupKeyFromContext(ctx) + groupKeySep + key
+	}
+	if f.options.GroupKeyPrefix != "" {
+		key = f.options.GroupKeyPrefix + groupKeySep + key
+	}
+	return key
+}
+
+// add adds d to the number of the waiters, and returns it.
+func (c *waiterCounter) add(k waiterKey, d int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.m[k] += d
+	n := c.m[k]
+	if n == 0 {
+		delete(c.m, k)
+	}
+	return n
+}
+
+// Ping checks the cache backend is reachable. The client must implement Pinger.
+func (f *cacheFetcherImpl) Ping(ctx context.Context) error {
+	p, ok := f.client.(Pinger)
+	if !ok {
+		return ErrNotPinger
+	}
+	return p.Ping(ctx)
+}
+
+// Close releases the client's resources. It is no-op if the client does not implement Closer.
+// The client is shared by the fetchers of the factory, so the other fetchers can not use it after Close.
+// The pending AsyncWrite writes are flushed and the workers are stopped before closing.
+func (f *cacheFetcherImpl) Close() error {
+	if f.options.writer != nil {
+		f.options.writer.close()
+	}
+
+	c, ok := f.client.(Closer)
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+// withClientTimeout calls the client function of the fetcher's key with Options.ClientTimeout.
+// The empty key returns ErrEmptyKey without calling fn, so no operation reads or writes the shared "" entry.
+func (f *cacheFetcherImpl) withClientTimeout(fn func() error) error {
+	if err := f.checkKey(); err != nil {
+		return err
+	}
+	return f.withKeysClientTimeout(fn)
+}
+
+// checkKey returns ErrEmptyKey if the fetcher's key is empty, e.g. the ignored SetKey error.
+func (f *cacheFetcherImpl) checkKey() error {
+	if f.key == "" {
+		return ErrEmptyKey
+	}
+	return nil
+}
+
+// withKeysClientTimeout calls the client function with Options.ClientTimeout. It is for the given keys, e.g. GetMany, not the fetcher's key.
+// The client interface has no context, so fn keeps running in the background after the timeout.
+// It is short-circuited by the open circuit breaker. The error other than cache miss is wrapped with the key.
+func (f *cacheFetcherImpl) withKeysClientTimeout(fn func() error) error {
+	return f.withKey(f.withBreaker(func() error {
+		if f.options.ClientTimeout == 0 {
+			return fn()
+		}
+
+		ch := make(chan error, 1)
+		atomic.AddInt32(&f.inFlight, 1)
+		go func() {
+			defer atomic.AddInt32(&f.inFlight, -1)
+			ch <- fn()
+		}()
+
+		timeout, stop := f.options.Clock.Timer(f.options.ClientTimeout)
+		defer stop()
+
+		select {
+		case err := <-ch:
+			return err
+		case <-timeout:
+			return ErrClientTimeout
+		}
+	}))
+}
+
+// withKey wraps err with the key for debugging. errors.Is and errors.As see the underlying error.
+// The cache miss is not wrapped, so that the client's IsErrCacheMiss comparing it directly works.
+func (f *cacheFetcherImpl) withKey(err error) error {
+	return f.withGivenKey(f.key, err)
+}
+
+// withGivenKey is withKey with key, e.g. in the background.
+func (f *cacheFetcherImpl) withGivenKey(key string, err error) error {
+	if err == nil || key == "" || f.isErrCacheMiss(err) {
+		return err
+	}
+	return fmt.Errorf("cachefetcher: key %q: %w", key, err)
+}
+
+// isErrCacheMiss detects the cache miss with Options.IsCacheMiss, or the client's IsErrCacheMiss.
+func (f *cacheFetcherImpl) isErrCacheMiss(err error) bool {
+	if f.options.IsCacheMiss != nil {
+		return f.options.IsCacheMiss(err)
+	}
+	return f.client.IsErrCacheMiss(err)
+}
+
 func (f *cacheFetcherImpl) isErrOtherThanCacheMiss(err error) bool {
-	return err != nil && !f.client.IsErrCacheMiss(err)
+	return err != nil && !f.isErrCacheMiss(err)
 }
 
-func (f *cacheFetcherImpl) debugPrint(shared bool) error {
-	var err error
+// isErrOnFetch reports whether fetch returns err.
+// If TreatDecodeErrorAsMiss, the decode error is notified to the hook and treated as cache miss.
+func (f *cacheFetcherImpl) isErrOnFetch(err error) bool {
+	if !f.isErrOtherThanCacheMiss(err) {
+		return false
+	}
+
+	if f.options.TreatDecodeErrorAsMiss && isSerializedErr(err) {
+		f.notify(Event{Op: opDecode, Key: f.key, Err: err})
+		return false
+	}
+	return true
+}
+
+// debugPrintErr prints "<op>: key:<key>, err:<err>, elapsed:<duration>", and returns err.
+// A cache miss is notified to the hook as not cached without Err.
+func (f *cacheFetcherImpl) debugPrintErr(err error, start time.Time) error {
+	e := Event{Op: callerName(), Key: f.key, Elapsed: f.options.Clock.Now().Sub(start), Tier: f.takeTier()}
+	if !f.isErrCacheMiss(err) {
+		e.Err = err
+	}
+
+	f.notify(e)
+
 	if f.options.DebugPrintMode {
-		pc, _, _, _ := runtime.Caller(skip)
-		names := strings.Split(runtime.FuncForPC(pc).Name(), "/")
+		_ = f.printf("%+v: key:%+v, err:%+v, elapsed:%+v\n", e.Op, e.Key, err, e.Elapsed)
+	}
+	return err
+}
+
+// callerName returns the name of the public method that calls debugPrint.
+func callerName() string {
+	pc, _, _, _ := runtime.Caller(skip + 1)
+	names := strings.Split(runtime.FuncForPC(pc).Name(), "/")
+	return names[len(names)-1]
+}
+
+// printf prints the debug line to Options.Logger, or to stdout with pp.
+func (f *cacheFetcherImpl) printf(format string, a ...interface{}) error {
+	if f.options.Logger != nil {
+		f.options.Logger.Printf(format, a...)
+		return nil
+	}
+
+	_, err := pp.Printf(format, a...)
+	return err
+}
+
+// isFallbackToFetcher reports whether Fetch calls fetcher function without cache on the backend error.
+// The backend error is notified to the hook.
+func (f *cacheFetcherImpl) isFallbackToFetcher(err error) bool {
+	if !f.options.FallbackToFetcherOnError || !f.isErrOtherThanCacheMiss(err) {
+		return false
+	}
 
-		if f.isCached {
-			_, err = pp.Printf("%+v: key:%+v, cache:%+v\n", names[len(names)-1], f.key, f.isCached)
-		} else if shared {
-			_, err = pp.Printf("%+v: key:%+v, shared:%+v\n", names[len(names)-1], f.key, shared)
+	for _, e := range []error{
+		ErrEmptyKey, ErrNoPointerType, ErrInterfaceType, ErrInvalidExpiration, ErrGobSerialized, ErrSerialized, ErrCompression, ErrValueTooLarge,
+	} {
+		if errors.Is(err, e) {
+			return false // not the backend error.
+		}
+	}
+
+	f.notify(Event{Op: opBackend, Key: f.key, Err: err})
+	return true
+}
+
+func (f *cacheFetcherImpl) notify(e Event) {
+	f.options.stats.count(e)
+	if f.options.DebugPrintHook != nil {
+		f.options.DebugPrintHook(e)
+	}
+}
+
+// debugPrint prints "<op>: key:<key>, cache:<isCached>, shared:<shared>, waiters:<waiters>, elapsed:<duration>".
+// shared and waiters are omitted when cached, and cache is omitted when shared.
+func (f *cacheFetcherImpl) debugPrint(res result, start time.Time) error {
+	e := Event{
+		Op:       callerName(),
+		Key:      f.key,
+		IsCached: f.cached(),
+		Shared:   res.Shared,
+		Waiters:  res.Waiters,
+		Elapsed:  f.options.Clock.Now().Sub(start),
+		Tier:     f.takeTier(),
+	}
+
+	f.notify(e)
+
+	var err error
+	if f.options.DebugPrintMode {
+		if e.IsCached && e.Tier != "" {
+			err = f.printf("%+v: key:%+v, cache:%+v, tier:%+v, elapsed:%+v\n", e.Op, e.Key, e.IsCached, e.Tier, e.Elapsed)
+		} else if e.IsCached {
+			err = f.printf("%+v: key:%+v, cache:%+v, elapsed:%+v\n", e.Op, e.Key, e.IsCached, e.Elapsed)
+		} else if e.Shared {
+			err = f.printf("%+v: key:%+v, shared:%+v, waiters:%+v, elapsed:%+v\n", e.Op, e.Key, e.Shared, e.Waiters, e.Elapsed)
 		} else {
-			_, err = pp.Printf("%+v: key:%+v, cache:%+v, shared:%+v\n", names[len(names)-1], f.key, f.isCached, shared)
+			err = f.printf("%+v: key:%+v, cache:%+v, shared:%+v, elapsed:%+v\n", e.Op, e.Key, e.IsCached, e.Shared, e.Elapsed)
 		}
 
 		return err
@@ -1,15 +1,25 @@
 package cachefetcher_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/gob"
 	"errors"
+	"expvar"
+	"net"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/peutes/go-cache-fetcher/cachefetcher"
+	"golang.org/x/sync/singleflight"
 )
 
 const host = "localhost:6379"
@@ -58,12 +68,148 @@ type (
 	testStruct2 struct {
 		P *testStruct
 	}
+	testStructInterface struct {
+		V interface{}
+	}
+	testConcrete struct {
+		A int
+		B string
+	}
+	testGobType struct {
+		A int
+	}
+	testGobConflictType struct {
+		A int
+	}
+	testKeyStruct struct {
+		ID      int    `json:"id"`
+		Name    string `json:"name,omitempty"`
+		Secret  string `json:"-"`
+		NoTag   bool
+		private int
+	}
+	testTextKey struct {
+		ID int
+	}
+	testCacheKey struct {
+		ID int
+	}
 )
 
 func (testStructEmpty) String() string {
 	return "testStructEmpty"
 }
 
+func (k testTextKey) MarshalText() ([]byte, error) {
+	return []byte("id-" + strconv.Itoa(k.ID)), nil
+}
+
+func (k testTextKey) String() string {
+	return "stringer"
+}
+
+func (k testCacheKey) CacheKey() string {
+	return "user:" + strconv.Itoa(k.ID)
+}
+
+func (k testCacheKey) String() string {
+	return "stringer"
+}
+
+// slowClient is a test client that sleeps before Get.
+type slowClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	delay time.Duration
+}
+
+func (c *slowClient) Get(key string, dst interface{}) error {
+	time.Sleep(c.delay)
+	return c.SimpleRedisClientImpl.Get(key, dst)
+}
+
+var _ cachefetcher.Clock = (*fakeClock)(nil)
+
+// fakeClock is a test clock that advances step on each Now, and fires After immediately.
+type fakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Now().Add(d)
+	return ch
+}
+
+func (c *fakeClock) Timer(d time.Duration) (<-chan time.Time, func()) {
+	return c.After(d), func() {}
+}
+
+// tieredClient is a test TieredClient with the local map as L1.
+type tieredClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	l1 map[string]string
+}
+
+func (c *tieredClient) GetWithTier(key string, dst interface{}) (cachefetcher.Tier, error) {
+	if v, ok := c.l1[key]; ok {
+		*dst.(*string) = v
+		return cachefetcher.TierL1, nil
+	}
+
+	if err := c.Get(key, dst); err != nil {
+		if c.IsErrCacheMiss(err) {
+			return cachefetcher.TierMiss, err
+		}
+		return "", err
+	}
+	c.l1[key] = *dst.(*string)
+	return cachefetcher.TierL2, nil
+}
+
+// obscuringClient is a test client that wraps the errors without Unwrap.
+type obscuringClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+}
+
+type obscuredError struct {
+	err error
+}
+
+func (e *obscuredError) Error() string {
+	return "obscured: " + e.err.Error()
+}
+
+func (c *obscuringClient) Get(key string, dst interface{}) error {
+	if err := c.SimpleRedisClientImpl.Get(key, dst); err != nil {
+		return &obscuredError{err: err}
+	}
+	return nil
+}
+
+type downClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+}
+
+var errDown = errors.New("down")
+
+func (c *downClient) Get(key string, dst interface{}) error {
+	return errDown
+}
+
+func (c *downClient) Set(key string, value interface{}, expiration time.Duration) error {
+	return errDown
+}
+
 // nolint: staticcheck
 func TestMain(m *testing.M) {
 	redisClient = &cachefetcher.SimpleRedisClientImpl{
@@ -212,422 +358,3030 @@ func Test_SetKey(t *testing.T) {
 	}
 }
 
-func TestSetKeyWithHash(t *testing.T) {
+func TestBuildKey(t *testing.T) {
 	before()
 
-	type args struct {
-		prefixes []string
-		elements []interface{}
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "set"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	key, err := f.BuildKey([]string{"prefix", "key"}, "build", 1)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := "prefix_key_build_1"
+	if key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
+
+	key, err = f.BuildHashKey([]string{"prefix", "key"}, "hoge")
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want = "prefix_key_ecb666d778725ec97307044d642bf4d160aabb76f56c0069c71ea25b1e926825"
+	if key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
+
+	if _, err := f.BuildKey([]string{"prefix", "key"}, nil); !errors.Is(err, cachefetcher.ErrInvalidKeyElements) {
+		t.Errorf("%#v", err)
 	}
 
+	// not mutated.
+	want = "prefix_key_set"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
+}
+
+func TestSetKeyEmptyPrefixes(t *testing.T) {
+	before()
+
 	tests := []struct {
-		name string
-		args args
-		want string
-		err  error
+		name     string
+		prefixes []string
+		want     string
+		err      error
 	}{
-		{
-			"prefix",
-			args{
-				[]string{"prefix", "key"},
-				nil,
-			},
-			"prefix_key",
-			nil,
-		},
-		{
-			"space",
-			args{
-				[]string{"prefix", " k e y "},
-				nil,
-			},
-			"prefix__k_e_y_",
-			nil,
-		},
-		{
-			"strings",
-			args{
-				[]string{"prefix", "key"},
-				[]interface{}{"hoge", "fugadddddddd"},
-			},
-			"prefix_key_a31d03600d04dd35fc74f8489c9347d154074699ddb37ca893f3a0a9e20ac09d",
-			nil,
-		},
-		{
-			"README",
-			args{
-				[]string{"prefix", "any"},
-				[]interface{}{1, 0.1, true, &[]string{"a", "b"}, time.Unix(0, 0).In(time.UTC)},
-			},
-			"prefix_any_c94a415eb6e20585f4fbc856b6edcf52007259522967c4bea548515e71531663",
-			nil,
-		},
+		{"nil", nil, "key", nil},
+		{"empty", []string{""}, "key", nil},
+		{"whitespace", []string{" ", "\t"}, "key", nil},
+		{"leading", []string{"", "prefix"}, "prefix_key", nil},
+		{"middle", []string{"prefix", "", "sub"}, "prefix_sub_key", nil},
+		{"trailing", []string{"prefix", " "}, "prefix_key", nil},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			f := factory.NewFetcher()
-			if err := f.SetHashKey(tt.args.prefixes, tt.args.elements...); !errors.Is(err, tt.err) {
+			if err := f.SetKey(tt.prefixes, "key"); err != nil {
 				t.Errorf("%#v, %#v", tt.name, err)
 			}
 
-			key := f.Key()
-			if key != tt.want {
+			if key := f.Key(); key != tt.want {
 				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
 			}
+
+			rf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{RequirePrefixes: true}).NewFetcher()
+			if err := rf.SetKey(tt.prefixes, "key"); (err == nil) == (tt.want == "key") {
+				t.Errorf("%#v, %#v", tt.name, err)
+			} else if err != nil && !errors.Is(err, cachefetcher.ErrEmptyPrefixes) {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
 		})
 	}
 }
 
-func TestFetch(t *testing.T) {
+func TestSetKeyError(t *testing.T) {
 	before()
 
-	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key ptr"}, "hoge", "fuga"); err != nil {
-		t.Errorf("%#v", err)
+	tests := []struct {
+		name     string
+		elements []interface{}
+		index    int
+		kind     reflect.Kind
+	}{
+		{"nil", []interface{}{"a", nil}, 1, reflect.Invalid},
+		{"map", []interface{}{map[int]int{}}, 0, reflect.Map},
+		{"slice", []interface{}{"a", 1, []interface{}{"b", func() {}}}, 2, reflect.Func},
+		{"struct", []interface{}{&testStruct{}}, 0, reflect.Struct},
 	}
 
-	// first fetch read from fetcher.
-	var dst string
-	want := "piyo"
-	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
-		return want, nil
-	}); err != nil {
-		t.Errorf("%#v", err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := factory.NewFetcher()
+			err := f.SetKey([]string{"prefix", "key"}, tt.elements...)
+			if !errors.Is(err, cachefetcher.ErrInvalidKeyElements) {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
+
+			var ke *cachefetcher.KeyError
+			if !errors.As(err, &ke) {
+				t.Fatalf("%#v, %#v", tt.name, err)
+			}
+
+			want := &cachefetcher.KeyError{Prefixes: []string{"prefix", "key"}, ElementIndex: tt.index, Kind: tt.kind}
+			if !reflect.DeepEqual(ke, want) {
+				t.Errorf("%#v: %#v is not %#v", tt.name, ke, want)
+			}
+		})
 	}
+}
 
-	if f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+func TestSetKeyWithUnambiguousCollections(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name     string
+		elements []interface{}
+		want     string
+	}{
+		{"slice", []interface{}{[]string{"a", "b"}}, "prefix_[a,b]"},
+		{"scalars", []interface{}{"a", "b"}, "prefix_a_b"},
+		{"nested", []interface{}{[][]int{{1, 2}, {3}}, "c"}, "prefix_[[1,2],[3]]_c"},
+		{"array", []interface{}{[2]int{1, 2}}, "prefix_[1,2]"},
+		{"empty", []interface{}{[]string{}}, "prefix_[]"},
 	}
 
-	if dst != want {
-		t.Errorf("%#v is not %#v", dst, want)
+	uf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{UnambiguousCollections: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := uf.NewFetcher()
+			if err := f.SetKey([]string{"prefix"}, tt.elements...); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if key := f.Key(); key != tt.want {
+				t.Errorf("%#v is not %#v", key, tt.want)
+			}
+		})
 	}
 
-	// second fetch read from cache.
-	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
-		return want, nil
-	}); err != nil {
+	// the default flattens the slice, and it collides with the scalars.
+	f1, f2 := factory.NewFetcher(), factory.NewFetcher()
+	if err := f1.SetKey([]string{"prefix"}, []string{"a", "b"}); err != nil {
 		t.Errorf("%#v", err)
 	}
-
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+	if err := f2.SetKey([]string{"prefix"}, "a", "b"); err != nil {
+		t.Errorf("%#v", err)
 	}
 
-	if dst != want {
-		t.Errorf("%#v is not %#v", dst, want)
+	if f1.Key() != f2.Key() {
+		t.Errorf("%#v is not %#v", f1.Key(), f2.Key())
 	}
 }
 
-func TestFetcherError(t *testing.T) {
+func TestSetKeyStruct(t *testing.T) {
 	before()
 
-	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key ptr"}, "hoge", "fuga"); err != nil {
-		t.Errorf("%#v", err)
+	e := testKeyStruct{ID: 1, Secret: "s", NoTag: true, private: 2}
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+		want    string
+	}{
+		{"json", &cachefetcher.Options{}, "prefix_id:1_name:_NoTag:true"},
+		{"omitempty", &cachefetcher.Options{KeyStructOmitEmpty: true}, "prefix_id:1_NoTag:true"},
+		{"untagged", &cachefetcher.Options{KeyStructTag: "key"}, "prefix_ID:1_Name:_Secret:s_NoTag:true"},
 	}
 
-	// first fetch read from fetcher.
-	errUnknown := errors.New("unknown error")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf := cachefetcher.NewFactory(redisClient, tt.options)
 
-	var dst string
-	want := "piyo"
-	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
-		return want, errUnknown
-	}); err != errUnknown {
-		t.Errorf("%#v", err)
+			f := sf.NewFetcher()
+			if err := f.SetKey([]string{"prefix"}, e); err != nil {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
+
+			if key := f.Key(); key != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+			}
+		})
 	}
+}
 
-	if f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+func TestSetKeyWithPrefix(t *testing.T) {
+	before()
+
+	pf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{KeyPrefix: "svcA"})
+
+	f := pf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hoge"); err != nil {
+		t.Errorf("%#v", err)
 	}
 
-	if dst != "" {
-		t.Errorf("%#v is not %#v", dst, want)
+	want := "svcA_prefix_key_hoge"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
 	}
 
-	if err := f.Get(&dst); err != redis.Nil {
+	if err := f.SetHashKey([]string{"prefix", "key"}, "hoge"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
+	want = "svcA_prefix_key_ecb666d778725ec97307044d642bf4d160aabb76f56c0069c71ea25b1e926825"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
 }
 
-func TestSet(t *testing.T) {
+func TestSetKeyGolden(t *testing.T) {
 	before()
 
-	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, "hoge", "fuga"); err != nil {
-		t.Errorf("%#v", err)
+	tests := []struct {
+		name    string
+		element interface{}
+		want    string
+	}{
+		{"string", "abc", "prefix_abc"},
+		{"bool", true, "prefix_true"},
+		{"int", -1, "prefix_-1"},
+		{"int8", int8(-8), "prefix_-8"},
+		{"int16", int16(-16), "prefix_-16"},
+		{"int32", int32(-32), "prefix_-32"},
+		{"int64", int64(-64), "prefix_-64"},
+		{"uint", uint(1), "prefix_1"},
+		{"uint8", uint8(8), "prefix_8"},
+		{"uint16", uint16(16), "prefix_16"},
+		{"uint32", uint32(32), "prefix_32"},
+		{"uint64", uint64(64), "prefix_64"},
+		{"uintptr", uintptr(1), "prefix_1"},
+		{"float32", float32(0.1), "prefix_0.1"},
+		{"float64", 1e21, "prefix_1e+21"},
+		{"complex64", complex64(complex(1, -2)), "prefix_(1-2i)"},
+		{"complex128", complex(1.1, 1.2), "prefix_(1.1+1.2i)"},
+		{"named", unique("u"), "prefix_u"},
+		{"stringer", testStructEmpty{}, "prefix_testStructEmpty"},
+		{"text marshaler", testTextKey{ID: 1}, "prefix_id-1"},
+		{"text marshaler pointer", &testTextKey{ID: 2}, "prefix_id-2"},
+		{"cache key", testCacheKey{ID: 1}, "prefix_user:1"},
+		{"cache key pointer", &testCacheKey{ID: 2}, "prefix_user:2"},
+		{"text marshaler slice", net.IPv4(127, 0, 0, 1), "prefix_127.0.0.1"},
+		{"time", zerotime, "prefix_1970-01-01_00:00:00_+0000_UTC"},
+		{"time nanosecond", zerotime.Add(time.Nanosecond), "prefix_1970-01-01_00:00:00.000000001_+0000_UTC"},
 	}
 
-	if err := f.Set("value", 10*time.Second); err != nil {
-		t.Errorf("%#v", err)
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := factory.NewFetcher()
+			if err := f.SetKey([]string{"prefix"}, tt.element); err != nil {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
 
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+			if key := f.Key(); key != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+			}
+		})
 	}
 }
 
-func TestGetString(t *testing.T) {
+func TestSetKeyWithKeyEncoder(t *testing.T) {
 	before()
 
-	want := "value"
+	ef := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		KeyEncoder: func(element interface{}) (string, error) {
+			if _, ok := element.(int); !ok {
+				return "", cachefetcher.ErrInvalidKeyElements
+			}
+			return "i", nil
+		},
+	})
 
-	// use no serializer
-	f := factory.NewFetcher()
-	if err := f.SetHashKey([]string{"prefix", "key"}, want); err != nil {
+	f := ef.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, 1, 2); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.SetString(want, 10*time.Second); err != nil {
-		t.Errorf("%#v", err)
+	want := "prefix_key_i_i"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
 	}
 
-	dst, err := f.GetString()
-	if err != nil {
+	if err := f.SetKey([]string{"prefix", "key"}, "a"); !errors.Is(err, cachefetcher.ErrInvalidKeyElements) {
 		t.Errorf("%#v", err)
 	}
+}
 
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
-	}
+func TestSetKeyWithHashTag(t *testing.T) {
+	before()
 
-	if dst != want {
-		t.Errorf("%#v, is not %#v", dst, want)
-	}
+	hf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		KeyPrefix:     "svcA",
+		SchemaVersion: "v2",
+		HashTag: func(key string) string {
+			if !strings.HasPrefix(key, "user_") {
+				return ""
+			}
+			return strings.SplitN(key, "_", 3)[1]
+		},
+	})
 
-	// direct get
-	if dst2 := redisClient.Rdb.Get(ctx, f.Key()).Val(); dst2 != want {
-		t.Errorf("%#v, is not %#v", dst2, want)
+	f := hf.NewFetcher()
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     string
+	}{
+		{"tagged", []string{"user", "10"}, "svcA_{10}_user_10_profile_v2"},
+		{"untagged", []string{"item"}, "svcA_item_profile_v2"},
 	}
-}
+
+	for _, tt := range tests {
+		if err := f.SetKey(tt.prefixes, "profile"); err != nil {
+			t.Errorf("%#v, %#v", tt.name, err)
+		}
+
+		if key := f.Key(); key != tt.want {
+			t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+		}
+	}
+}
+
+func TestSetKeyWithDisableReplaceSpaces(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+		want    string
+	}{
+		{"replace", &cachefetcher.Options{}, "my_prefix_key_a_b"},
+		{"verbatim", &cachefetcher.Options{DisableReplaceSpaces: true}, "my prefix_key_a b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"my prefix", "key"}, "a b"); err != nil {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
+
+			if key := f.Key(); key != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKeyWithLowercaseKeys(t *testing.T) {
+	before()
+
+	lf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{LowercaseKeys: true})
+
+	f1, f2 := lf.NewFetcher(), lf.NewFetcher()
+	if err := f1.SetKey([]string{"User", "email"}, "Alice@Example.com"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f2.SetKey([]string{"user", "email"}, "alice@example.com"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := "user_email_alice@example.com"
+	if f1.Key() != want || f2.Key() != want {
+		t.Errorf("%#v, %#v is not %#v", f1.Key(), f2.Key(), want)
+	}
+
+	if err := f1.SetHashKey([]string{"user"}, "Alice"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f2.SetHashKey([]string{"user"}, "alice"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f1.Key() != f2.Key() {
+		t.Errorf("%#v is not %#v", f1.Key(), f2.Key())
+	}
+
+	// the default keeps the case.
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"user"}, "Alice"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if want := "user_Alice"; f.Key() != want {
+		t.Errorf("%#v is not %#v", f.Key(), want)
+	}
+}
+
+func TestSetHashKeyWithReadablePrefix(t *testing.T) {
+	before()
+
+	const hashLen = 64 // sha256 hex.
+	tests := []struct {
+		name     string
+		n        int
+		elements []interface{}
+		prefix   string
+	}{
+		{"default", 0, []interface{}{"user42", 1}, ""},
+		{"short", 6, []interface{}{"user42", 1}, "user42_"},
+		{"longer than element", 100, []interface{}{"user42", 1}, "user42_1_"},
+		{"multibyte", 2, []interface{}{"ユーザー"}, "ユー_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{HashKeyReadablePrefixLen: tt.n}).NewFetcher()
+			if err := f.SetHashKey([]string{"prefix", "key"}, tt.elements...); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			element := strings.TrimPrefix(f.Key(), "prefix_key_")
+			if !strings.HasPrefix(element, tt.prefix) || len(element) != len(tt.prefix)+hashLen {
+				t.Errorf("%#v", f.Key())
+			}
+		})
+	}
+}
+
+func TestKeyParts(t *testing.T) {
+	before()
+
+	kf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{KeyPrefix: "svcA", SchemaVersion: "v2"})
+
+	f := kf.NewFetcher()
+	if err := f.SetKey([]string{"", "user", "profile"}, 1, "a"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	prefixes, element := f.KeyParts()
+	if want := []string{"user", "profile"}; !reflect.DeepEqual(prefixes, want) || element != "1_a" {
+		t.Errorf("%#v, %#v", prefixes, element)
+	}
+
+	if err := f.SetHashKey([]string{"user"}, 1, "a"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	prefixes, element = f.KeyParts()
+	if want := []string{"user"}; !reflect.DeepEqual(prefixes, want) || !strings.HasSuffix(f.Key(), element+"_v2") || len(element) != 64 {
+		t.Errorf("%#v, %#v", prefixes, element)
+	}
+}
+
+func TestSetKeyWithSchemaVersion(t *testing.T) {
+	before()
+
+	vf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{KeyPrefix: "svcA", SchemaVersion: "v2"})
+
+	f := vf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hoge"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := "svcA_prefix_key_hoge_v2"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
+
+	if err := f.SetKey([]string{"prefix", "key"}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want = "svcA_prefix_key_v2"
+	if key := f.Key(); key != want {
+		t.Errorf("%#v is not %#v", key, want)
+	}
+}
+
+func TestSetKeyWithTimeKeyLayout(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name   string
+		layout string
+		want   string
+	}{
+		{"default", "", "prefix_key_1970-01-01_00:00:00_+0000_UTC"},
+		{"unix", cachefetcher.TimeKeyLayoutUnix, "prefix_key_0"},
+		{"RFC3339", time.RFC3339, "prefix_key_1970-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{TimeKeyLayout: tt.layout})
+
+			f := lf.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, &zerotime); err != nil {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
+
+			if key := f.Key(); key != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKeyWithHash(t *testing.T) {
+	before()
+
+	type args struct {
+		prefixes []string
+		elements []interface{}
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+		err  error
+	}{
+		{
+			"prefix",
+			args{
+				[]string{"prefix", "key"},
+				nil,
+			},
+			"prefix_key",
+			nil,
+		},
+		{
+			"space",
+			args{
+				[]string{"prefix", " k e y "},
+				nil,
+			},
+			"prefix__k_e_y_",
+			nil,
+		},
+		{
+			"strings",
+			args{
+				[]string{"prefix", "key"},
+				[]interface{}{"hoge", "fugadddddddd"},
+			},
+			"prefix_key_a31d03600d04dd35fc74f8489c9347d154074699ddb37ca893f3a0a9e20ac09d",
+			nil,
+		},
+		{
+			"README",
+			args{
+				[]string{"prefix", "any"},
+				[]interface{}{1, 0.1, true, &[]string{"a", "b"}, time.Unix(0, 0).In(time.UTC)},
+			},
+			"prefix_any_c94a415eb6e20585f4fbc856b6edcf52007259522967c4bea548515e71531663",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := factory.NewFetcher()
+			if err := f.SetHashKey(tt.args.prefixes, tt.args.elements...); !errors.Is(err, tt.err) {
+				t.Errorf("%#v, %#v", tt.name, err)
+			}
+
+			key := f.Key()
+			if key != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, key, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key ptr"}, "hoge", "fuga"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// first fetch read from fetcher.
+	var dst string
+	want := "piyo"
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	// second fetch read from cache.
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+}
+
+// TestSharedFetcher is for the race detector. The fetcher shared by goroutines writes the result of the last operation
+// from the singleflight goroutines, and IsCached reads it.
+func TestSharedFetcher(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Group: &singleflight.Group{}}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "shared"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var dst string
+			if err := f.Fetch(10*time.Second, &dst, func() (string, error) { return "value", nil }); err != nil {
+				t.Errorf("%#v", err)
+			}
+			if err := f.Get(&dst); err != nil || dst != "value" {
+				t.Errorf("%#v, %#v", err, dst)
+			}
+			_ = f.IsCached()
+
+			// the result of the specific call.
+			fromCache, err := f.GetOrSet(10*time.Second, &dst, func() (string, error) { return "value", nil })
+			if err != nil || !fromCache {
+				t.Errorf("%#v, %#v", err, fromCache)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "getorset"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	want := "piyo"
+	fromCache, err := f.GetOrSet(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	})
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if fromCache {
+		t.Errorf("%#v", fromCache)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	fromCache, err = f.GetOrSet(10*time.Second, &dst, func() (string, error) {
+		return "fuga", nil
+	})
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !fromCache {
+		t.Errorf("%#v", fromCache)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+}
+
+func TestFetchWithContext(t *testing.T) {
+	before()
+
+	type ctxKey struct{}
+	cctx := context.WithValue(ctx, ctxKey{}, "piyo")
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "context"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.FetchWithContext(cctx, 10*time.Second, &dst, func(c context.Context) (string, error) {
+		return c.Value(ctxKey{}).(string), nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "piyo" {
+		t.Errorf("%#v is not %#v", dst, "piyo")
+	}
+
+	// Fetch passes context.Background().
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func(c context.Context) (string, error) {
+		if c == nil {
+			return "", errors.New("nil context")
+		}
+		return "fuga", nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "fuga" {
+		t.Errorf("%#v is not %#v", dst, "fuga")
+	}
+}
+
+func TestLockedFetch(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "locked"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	lockKey := f.Key() + "\x00lock"
+
+	// acquire lock and read from fetcher.
+	var dst string
+	want := "piyo"
+	if err := f.LockedFetch(10*time.Second, time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if n := redisClient.Rdb.Exists(ctx, lockKey).Val(); n != 0 {
+		t.Errorf("lock is not released: %#v", n)
+	}
+
+	// the other process has the lock, and sets cache later.
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	redisClient.Rdb.Set(ctx, lockKey, "1", time.Second)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		redisClient.Rdb.Set(ctx, f.Key(), "winner", 10*time.Second)
+	}()
+
+	if err := f.LockedFetch(10*time.Second, time.Second, &dst, func() (string, error) {
+		return "loser", nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != "winner" {
+		t.Errorf("%#v is not %#v", dst, "winner")
+	}
+
+	// the other process has the lock, but does not set cache.
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	redisClient.Rdb.Set(ctx, lockKey, "1", time.Second)
+
+	if err := f.LockedFetch(10*time.Second, 200*time.Millisecond, &dst, func() (string, error) {
+		return "fallback", nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != "fallback" {
+		t.Errorf("%#v is not %#v", dst, "fallback")
+	}
+
+	// the lock expires during the fetcher, and the other process takes it.
+	for _, ff := range []cachefetcher.CacheFetcher{f, cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()} {
+		if err := ff.SetKey([]string{"prefix", "key"}, "locked"); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if err := ff.Del(); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := ff.LockedFetch(10*time.Second, time.Second, &dst, func() (string, error) {
+			redisClient.Rdb.Set(ctx, lockKey, "other", time.Second)
+			return want, nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if v := redisClient.Rdb.Get(ctx, lockKey).Val(); v != "other" {
+			t.Errorf("the lock of the other process is released: %#v", v)
+		}
+		redisClient.Rdb.Del(ctx, lockKey)
+	}
+
+	// the lock key does not collide with the key of SetKey.
+	if err := f.SetKey([]string{"prefix", "key", "locked"}, "lock"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if f.Key() == lockKey {
+		t.Errorf("%#v collides", f.Key())
+	}
+}
+
+func TestFetchWithoutSingleflight(t *testing.T) {
+	before()
+
+	nf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{DisableSingleflight: true})
+
+	f := nf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "nosingleflight"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	want := "piyo"
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+}
+
+func TestFetcherError(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key ptr"}, "hoge", "fuga"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// first fetch read from fetcher.
+	errUnknown := errors.New("unknown error")
+
+	var dst string
+	want := "piyo"
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, errUnknown
+	}); err != errUnknown {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != "" {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if err := f.Get(&dst); err != redis.Nil {
+		t.Errorf("%#v", err)
+	}
+
+}
+
+func TestFetchDecodeErrorAsMiss(t *testing.T) {
+	before()
+
+	var events []cachefetcher.Event
+	df := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		TreatDecodeErrorAsMiss: true,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			events = append(events, e)
+		},
+	})
+
+	f := df.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "decode"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// broken value.
+	if err := f.Set("a", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst int
+	want := 100
+	if err := f.Fetch(10*time.Second, &dst, func() (int, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if len(events) < 2 || !errors.Is(events[1].Err, cachefetcher.ErrGobSerialized) {
+		t.Errorf("%#v", events)
+	}
+
+	// overwritten.
+	var dst2 int
+	if err := f.Get(&dst2); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst2 != want {
+		t.Errorf("%#v is not %#v", dst2, want)
+	}
+}
+
+func TestFetchFallbackToFetcherOnError(t *testing.T) {
+	before()
+
+	var events []cachefetcher.Event
+	client := &downClient{SimpleRedisClientImpl: redisClient}
+	df := cachefetcher.NewFactory(client, &cachefetcher.Options{
+		FallbackToFetcherOnError: true,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			events = append(events, e)
+		},
+	})
+
+	f := df.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "fallback"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	want := "abc"
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if len(events) < 1 || !errors.Is(events[0].Err, errDown) {
+		t.Errorf("%#v", events)
+	}
+
+	// without fallback.
+	f = cachefetcher.NewFactory(client, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "fallback"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, nil
+	}); !errors.Is(err, errDown) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestErrorWithKey(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(&downClient{SimpleRedisClientImpl: redisClient}, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "errkey"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	err := f.Fetch(10*time.Second, &dst, func() (string, error) { return "value", nil })
+	if !errors.Is(err, errDown) || !strings.Contains(err.Error(), `key "prefix_key_errkey"`) {
+		t.Errorf("%#v", err)
+	}
+
+	// the decode error.
+	f = factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "errkey"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	redisClient.Rdb.Set(ctx, f.Key(), "broken", 10*time.Second)
+
+	var n int
+	if err := f.Get(&n); !errors.Is(err, cachefetcher.ErrGobSerialized) || !strings.Contains(err.Error(), f.Key()) {
+		t.Errorf("%#v", err)
+	}
+
+	// the cache miss is not wrapped.
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f.Get(&n); !redisClient.IsErrCacheMiss(err) || strings.Contains(err.Error(), f.Key()) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestFetchWithIsCacheMiss(t *testing.T) {
+	before()
+
+	client := &obscuringClient{SimpleRedisClientImpl: redisClient}
+	fetcher := func() (string, error) {
+		return "value", nil
+	}
+
+	f := cachefetcher.NewFactory(client, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "ismiss"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	var oe *obscuredError
+	if err := f.Fetch(10*time.Second, &dst, fetcher); !errors.As(err, &oe) {
+		t.Errorf("%#v", err)
+	}
+
+	f = cachefetcher.NewFactory(client, &cachefetcher.Options{
+		IsCacheMiss: func(err error) bool {
+			var oe *obscuredError
+			return errors.As(err, &oe) && errors.Is(oe.err, redis.Nil)
+		},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "ismiss"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, fetcher); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "value" {
+		t.Errorf("%#v is not %#v", dst, "value")
+	}
+}
+
+func TestFetchInto(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "into"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	fetcher := func() ([]int, error) {
+		return []int{2, 3}, nil
+	}
+
+	dst := make([]int, 0, 10)
+	dst = append(dst, 1)
+
+	// miss
+	if err := f.FetchInto(10*time.Second, &dst, fetcher); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := []int{1, 2, 3}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	// hit
+	if err := f.FetchInto(10*time.Second, &dst, fetcher); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	want = []int{1, 2, 3, 2, 3}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	var s string
+	if err := f.FetchInto(10*time.Second, &s, fetcher); !errors.Is(err, cachefetcher.ErrNoSliceType) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestFetchSkipCache(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "skip"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	want := "degraded"
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return want, cachefetcher.SkipCache
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	ok, err := f.Exists()
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+	if ok {
+		t.Errorf("%#v", ok)
+	}
+}
+
+func TestFetchPanic(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "panic"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		var m map[string]string
+		m["a"] = "b" // nil map write.
+		return "", nil
+	})
+	if !errors.Is(err, cachefetcher.ErrFetcherPanic) || !strings.Contains(err.Error(), "nil map") {
+		t.Errorf("%#v", err)
+	}
+
+	// the next call is not affected.
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) { return "value", nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "value" {
+		t.Errorf("%#v is not value", dst)
+	}
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	before()
+
+	under, over := bytes.Repeat([]byte("a"), 10), bytes.Repeat([]byte("a"), 11)
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{MaxValueBytes: 10}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "max"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(under, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(over, 10*time.Second); !errors.Is(err, cachefetcher.ErrValueTooLarge) {
+		t.Errorf("%#v", err)
+	}
+
+	var dst []byte
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f.Fetch(10*time.Second, &dst, func() ([]byte, error) { return over, nil }); !errors.Is(err, cachefetcher.ErrValueTooLarge) {
+		t.Errorf("%#v", err)
+	}
+
+	// SkipOversized returns the value without cache.
+	sf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{MaxValueBytes: 10, SkipOversized: true}).NewFetcher()
+	if err := sf.SetKey([]string{"prefix", "key"}, "max"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := sf.Fetch(10*time.Second, &dst, func() ([]byte, error) { return over, nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !reflect.DeepEqual(dst, over) {
+		t.Errorf("%#v is not %#v", dst, over)
+	}
+
+	if ok, err := sf.Exists(); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+}
+
+func TestFetchNilResult(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "nil"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testConcrete
+	if err := f.Fetch(10*time.Second, &dst, func() (*testConcrete, error) {
+		return nil, nil
+	}); !errors.Is(err, cachefetcher.ErrNilFetchResult) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func() (interface{}, error) {
+		return nil, nil
+	}); !errors.Is(err, cachefetcher.ErrNilFetchResult) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func() (interface{}, error) {
+		return (*testConcrete)(nil), nil
+	}); !errors.Is(err, cachefetcher.ErrNilFetchResult) {
+		t.Errorf("%#v", err)
+	}
+
+	var nilp *testConcrete
+	if err := f.Fetch(10*time.Second, &dst, func() (**testConcrete, error) {
+		return &nilp, nil
+	}); !errors.Is(err, cachefetcher.ErrNilFetchResult) {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+}
+
+func TestFetchPointerResult(t *testing.T) {
+	want := testConcrete{A: 1, B: "b"}
+	p := &want
+
+	tests := []struct {
+		name    string
+		fetcher interface{}
+	}{
+		{"value", func() (testConcrete, error) { return want, nil }},
+		{"pointer", func() (*testConcrete, error) { return &want, nil }},
+		{"pointer to pointer", func() (**testConcrete, error) { return &p, nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before()
+
+			f := factory.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "pointer"); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			// miss and hit.
+			for i := 0; i < 2; i++ {
+				var dst testConcrete
+				if err := f.Fetch(10*time.Second, &dst, tt.fetcher); err != nil {
+					t.Errorf("%#v", err)
+				}
+
+				if dst != want {
+					t.Errorf("%#v is not %#v", dst, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchEmptyCollection(t *testing.T) {
+	tests := []struct {
+		name    string
+		dst     interface{}
+		fetcher interface{}
+		want    interface{}
+	}{
+		{"slice", &[]int{}, func() ([]int, error) { return []int{}, nil }, []int{}},
+		{"map", &map[string]int{}, func() (map[string]int, error) { return map[string]int{}, nil }, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before()
+
+			f := factory.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "empty", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			// miss and hit.
+			for i, wantCached := range []bool{false, true} {
+				dst := reflect.New(reflect.TypeOf(tt.dst).Elem())
+				if err := f.Fetch(10*time.Second, dst.Interface(), tt.fetcher); err != nil {
+					t.Errorf("%#v", err)
+				}
+
+				if f.IsCached() != wantCached {
+					t.Errorf("%d: %#v is not %#v", i, f.IsCached(), wantCached)
+				}
+
+				if got := dst.Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%d: %#v is not %#v", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetcherErrorNotShared(t *testing.T) {
+	before()
+
+	errUnknown := errors.New("unknown error")
+	errored := make(chan struct{})
+	var once sync.Once
+	closeErrored := func() { once.Do(func() { close(errored) }) }
+	want := "piyo"
+
+	f1 := factory.NewFetcher()
+	if err := f1.SetKey([]string{"prefix", "key"}, "forget"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	f2 := factory.NewFetcher()
+	if err := f2.SetKey([]string{"prefix", "key"}, "forget"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		// f2 never waits forever, even if f1.Fetch fails before the fetcher.
+		defer closeErrored()
+
+		var dst string
+		if err := f1.Fetch(10*time.Second, &dst, func() (string, error) {
+			closeErrored()
+			return "", errUnknown
+		}); !errors.Is(err, errUnknown) {
+			t.Errorf("%#v", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		<-errored
+		time.Sleep(10 * time.Millisecond)
+
+		var dst string
+		if err := f2.Fetch(10*time.Second, &dst, func() (string, error) {
+			return want, nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if dst != want {
+			t.Errorf("%#v is not %#v", dst, want)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestSkipSingleflightOnHit(t *testing.T) {
+	before()
+
+	sf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{SkipSingleflightOnHit: true})
+
+	// the concurrent misses are still coalesced.
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			f := sf.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "skip"); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst string
+			if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+				atomic.AddInt32(&calls, 1)
+				once.Do(func() { close(started) })
+				<-release
+				return "value", nil
+			}); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if dst != "value" {
+				t.Errorf("%#v is not value", dst)
+			}
+		}()
+	}
+
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("%#v is not 1", calls)
+	}
+
+	// the hit is got directly.
+	f := sf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "skip"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "value" || !f.IsCached() {
+		t.Errorf("%#v, %#v", dst, f.IsCached())
+	}
+}
+
+func TestFetchTypeMismatch(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "mismatch"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testStructEmpty
+	if err := f.Fetch(10*time.Second, &dst, func() (*testConcrete, error) {
+		return &testConcrete{}, nil
+	}); !errors.Is(err, cachefetcher.ErrFetchTypeMismatch) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func() (interface{}, error) {
+		return testConcrete{}, nil
+	}); !errors.Is(err, cachefetcher.ErrFetchTypeMismatch) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hoge", "fuga"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+}
+
+func TestDebugPrintHook(t *testing.T) {
+	before()
+
+	var ops []string
+	hf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		DebugPrintHook: func(e cachefetcher.Event) {
+			if e.Elapsed < 0 {
+				t.Errorf("%#v", e.Elapsed)
+			}
+			ops = append(ops, e.Op)
+		},
+	})
+
+	f := hf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hook"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := []string{"cachefetcher.(*cacheFetcherImpl).Set", "cachefetcher.(*cacheFetcherImpl).Get"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("%#v is not %#v", ops, want)
+	}
+}
+
+func TestOnSet(t *testing.T) {
+	before()
+
+	type call struct {
+		key   string
+		value interface{}
+	}
+	var calls []call
+	options := &cachefetcher.Options{
+		OnSet: func(key string, value interface{}) { calls = append(calls, call{key, value}) },
+	}
+
+	f := cachefetcher.NewFactory(redisClient, options).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "onset"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(1, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst int
+	if err := f.Fetch(10*time.Second, &dst, func() (int, error) { return 2, nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the failed set is not called.
+	df := cachefetcher.NewFactory(&downClient{SimpleRedisClientImpl: redisClient}, options).NewFetcher()
+	if err := df.SetKey([]string{"prefix", "key"}, "onset"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := df.Set(3, 10*time.Second); err == nil {
+		t.Errorf("set must fail")
+	}
+
+	// SetHash and SetReader.
+	h := testConcrete{A: 3, B: "b"}
+	if err := f.SetHash(h, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	r := strings.NewReader("value")
+	if err := f.SetReader(r, -1, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := []call{{f.Key(), 1}, {f.Key(), 2}, {f.Key(), h}, {f.Key(), r}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("%#v is not %#v", calls, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	before()
+
+	options := &cachefetcher.Options{}
+	f := cachefetcher.NewFactory(redisClient, options).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "stats"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst int
+	if err := f.Get(&dst); !redisClient.IsErrCacheMiss(err) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Fetch(10*time.Second, &dst, func() (int, error) { return 1, nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(2, -1); !errors.Is(err, cachefetcher.ErrInvalidExpiration) {
+		t.Errorf("%#v", err)
+	}
+
+	// the fetcher of the other factory with the same options shares the stats.
+	f2 := cachefetcher.NewFactory(redisClient, options).NewFetcher()
+	if err := f2.SetKey([]string{"prefix", "key"}, "stats"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f2.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := cachefetcher.Stats{Hits: 1, Misses: 2, Sets: 1, Dels: 1, Errors: 1}
+	if got := f.Stats(); got != want {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	if got := factory.NewFetcher().Stats(); got == want {
+		t.Errorf("%#v must not be shared", got)
+	}
+}
+
+func TestIsReadOp(t *testing.T) {
+	tests := []struct {
+		op   string
+		want bool
+	}{
+		{"Get", true},
+		{"GetInto", true},
+		{"cachefetcher.(*cacheFetcherImpl).GetHash", true},
+		{"Set", false},
+		{"cachefetcher.(*cacheFetcherImpl).Del", false},
+		{"decode", false},
+	}
+
+	for _, tt := range tests {
+		if got := cachefetcher.IsReadOp(tt.op); got != tt.want {
+			t.Errorf("%#v: %#v is not %#v", tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestStatsExpvar(t *testing.T) {
+	before()
+
+	// the factories with the same name share the published map.
+	var fs []cachefetcher.CacheFetcher
+	for i := 0; i < 2; i++ {
+		f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{ExpvarName: "cachefetcher_test"}).NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "expvar"); err != nil {
+			t.Errorf("%#v", err)
+		}
+		fs = append(fs, f)
+	}
+
+	var dst int
+	if err := fs[0].Fetch(10*time.Second, &dst, func() (int, error) { return 1, nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := fs[1].Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	m, ok := expvar.Get("cachefetcher_test").(*expvar.Map)
+	if !ok {
+		t.Fatalf("%#v", expvar.Get("cachefetcher_test"))
+	}
+
+	for name, want := range map[string]string{"hits": "1", "misses": "1", "sets": "1", "dels": "0", "errors": "0"} {
+		if v := m.Get(name); v == nil || v.String() != want {
+			t.Errorf("%#v: %#v is not %#v", name, v, want)
+		}
+	}
+
+	// the other type of var is not replaced.
+	expvar.NewString("cachefetcher_test_string")
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{ExpvarName: "cachefetcher_test_string"}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "expvar"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil || f.Stats().Hits != 1 {
+		t.Errorf("%#v, %#v", err, f.Stats())
+	}
+}
+
+func TestDebugPrintHookWaiters(t *testing.T) {
+	before()
+
+	var mu sync.Mutex
+	var events []cachefetcher.Event
+	hf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		Group: &singleflight.Group{},
+		DebugPrintHook: func(e cachefetcher.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	})
+
+	n := 3
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			f := hf.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "waiters"); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst string
+			if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+				close(started)
+				<-release
+				return "piyo", nil
+			}); err != nil {
+				t.Errorf("%#v", err)
+			}
+		}(i)
+
+		if i == 0 {
+			<-started
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond) // wait for joining the call.
+	close(release)
+	wg.Wait()
+
+	max := 0
+	for _, e := range events {
+		if !e.Shared {
+			t.Errorf("%#v", e)
+		}
+		if e.Waiters > max {
+			max = e.Waiters
+		}
+	}
+
+	if max != n {
+		t.Errorf("%#v is not %#v", max, n)
+	}
+}
+
+func TestSetExpiration(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "expiration"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", -time.Second); !errors.Is(err, cachefetcher.ErrInvalidExpiration) {
+		t.Errorf("%#v", err)
+	}
+
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if err := f.Set("value", cachefetcher.NoExpiration); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ttl := redisClient.Rdb.TTL(ctx, f.Key()).Val(); ttl != -time.Second {
+		t.Errorf("%#v", ttl)
+	}
+}
+
+func TestDefaultExpiration(t *testing.T) {
+	before()
+
+	client := &expirationClient{SimpleRedisClientImpl: redisClient}
+	f := cachefetcher.NewFactory(client, &cachefetcher.Options{DefaultExpiration: time.Minute}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "defaultexpiration"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the explicit expiration overrides the default, and NoExpiration is not the default.
+	for _, tt := range []struct {
+		expiration, want time.Duration
+	}{
+		{cachefetcher.UseDefault, time.Minute},
+		{10 * time.Second, 10 * time.Second},
+		{cachefetcher.NoExpiration, 0},
+	} {
+		if err := f.Set("value", tt.expiration); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if client.expiration != tt.want {
+			t.Errorf("%#v is not %#v", client.expiration, tt.want)
+		}
+	}
+
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Fetch(cachefetcher.UseDefault, &dst, func() (string, error) { return "value", nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if client.expiration != time.Minute {
+		t.Errorf("%#v is not %#v", client.expiration, time.Minute)
+	}
+
+	if err := f.Set("value", -time.Second); !errors.Is(err, cachefetcher.ErrInvalidExpiration) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestGetString(t *testing.T) {
+	before()
+
+	want := "value"
+
+	// use no serializer
+	f := factory.NewFetcher()
+	if err := f.SetHashKey([]string{"prefix", "key"}, want); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetString(want, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	dst, err := f.GetString()
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != want {
+		t.Errorf("%#v, is not %#v", dst, want)
+	}
+
+	// direct get
+	if dst2 := redisClient.Rdb.Get(ctx, f.Key()).Val(); dst2 != want {
+		t.Errorf("%#v, is not %#v", dst2, want)
+	}
+}
+
+func TestGetRawBytes(t *testing.T) {
+	before()
+
+	want := []byte{0x00, 0x01, 0xfe, 0xff}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "raw"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetBytes(want, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	dst, err := f.GetBytes()
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("%#v, is not %#v", dst, want)
+	}
+
+	// direct get
+	if dst2, _ := redisClient.Rdb.Get(ctx, f.Key()).Bytes(); !reflect.DeepEqual(dst2, want) {
+		t.Errorf("%#v, is not %#v", dst2, want)
+	}
+}
 
 func TestGetInt(t *testing.T) {
 	before()
 
-	e := 100
-	var dst int
-
+	e := 100
+	var dst int
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if dst != e {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestGetSlice(t *testing.T) {
+	before()
+
+	e := []string{"a", "b", "c"}
+	var dst []string
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestGetMap(t *testing.T) {
+	before()
+
+	e := map[int]string{1: "a", 2: "b", 3: "c"}
+	var dst map[int]string
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "map"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	before()
+
+	e := []byte{0x00, 0x01, 0xfe, 0xff}
+	var dst []byte
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "bytes"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+
+	// stored raw without gob.
+	if dst2 := redisClient.Rdb.Get(ctx, f.Key()).Val(); dst2 != string(e) {
+		t.Errorf("%#v, is not %#v", dst2, string(e))
+	}
+}
+
+// gatedGetClient is a test client whose Get waits for release.
+type gatedGetClient struct {
+	cachefetcher.Client
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *gatedGetClient) Get(key string, dst interface{}) error {
+	c.entered <- struct{}{}
+	<-c.release
+	return c.Client.Get(key, dst)
+}
+
+func TestGetBytesWithGetString(t *testing.T) {
+	c := &gatedGetClient{Client: cachefetcher.NewFakeClient(), entered: make(chan struct{}, 2), release: make(chan struct{})}
+	cf := cachefetcher.NewFactory(c, nil)
+
+	f := cf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "bytes"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f.SetString("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if s, err := f.GetString(); err != nil || s != "value" {
+			t.Errorf("%#v, %#v", s, err)
+		}
+	}()
+	<-c.entered
+
+	// GetBytes during GetString of the same key.
+	go func() {
+		defer wg.Done()
+		f2 := cf.NewFetcher()
+		if err := f2.SetKey([]string{"prefix", "key"}, "bytes"); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if b, err := f2.GetBytes(); err != nil || string(b) != "value" {
+			t.Errorf("%#v, %#v", b, err)
+		}
+	}()
+	select {
+	case <-c.entered:
+	case <-time.After(time.Second): // joined the call of GetString.
+	}
+
+	close(c.release)
+	wg.Wait()
+}
+
+func TestGetFailed(t *testing.T) {
+	before()
+
+	e := "a"
+	var dst int
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrGobSerialized) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestGetInterface(t *testing.T) {
+	before()
+
+	var dst interface{}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "interface"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(1, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrInterfaceType) {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != nil {
+		t.Errorf("%#v", dst)
+	}
+}
+
+func TestGetStruct(t *testing.T) {
+	before()
+
+	i := 10
+	b := true
+	s := "abc"
+	ft := 0.123
+	i8 := int8(20)
+	i64 := int64(30)
+	ui8 := uint8(40)
+	ui64 := uint64(50)
+
+	e := &testStruct{
+		I:     i,
+		B:     b,
+		S:     s,
+		F:     ft,
+		I8:    i8,
+		I64:   i64,
+		UI8:   ui8,
+		UI64:  ui64,
+		IP:    &i,
+		BP:    &b,
+		SP:    &s,
+		FP:    &ft,
+		I8P:   &i8,
+		I64P:  &i64,
+		UI8P:  &ui8,
+		UI64P: &ui64,
+		INil:  nil,
+		SNil:  nil,
+		NullS: sql.NullString{String: s, Valid: true},
+		IS:    []int{i, i, i},
+		BS:    []bool{b, b, b},
+		SS:    []string{s, s, s},
+		FS:    []float64{ft, ft, ft},
+		IM:    map[int]int{1: i, 2: i, 3: i},
+		BM:    map[bool]bool{true: b, false: b},
+		SM:    map[string]string{"a": s, "bb": s, "ccc": s},
+		FM:    map[float64]float64{0.1: ft, 0.2: ft, 0.3: ft},
+	}
+
+	var dst testStruct
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "struct1"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	err := f.Get(&dst)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, *e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+
+	el := []testStruct{*e, *e}
+	var dstList []testStruct
+
+	f2 := factory.NewFetcher()
+	if err := f2.SetKey([]string{"prefix", "key"}, "struct2"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f2.Set(el, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f2.Get(&dstList); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f2.IsCached() {
+		t.Errorf("%#v", f2.IsCached())
+	}
+
+	if !reflect.DeepEqual(dstList, el) {
+		t.Errorf("%#v is not %#v", dstList, el)
+	}
+
+	e2 := &testStruct2{P: e}
+	var dst2 testStruct2
+
+	f3 := factory.NewFetcher()
+	if err := f3.SetKey([]string{"prefix", "key"}, "struct3"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f3.Set(e2, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f3.Get(&dst2); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f3.IsCached() {
+		t.Errorf("%#v", f3.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst2, *e2) {
+		t.Errorf("%#v is not %#v", dst2, e2)
+	}
+}
+
+func TestSetGetZeroValue(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name  string
+		value interface{}
+		dst   interface{}
+	}{
+		{"string", "", new(string)},
+		{"bytes", []byte{}, new([]byte)},
+		{"int", 0, new(int)},
+		{"struct", testConcrete{}, new(testConcrete)},
+		{"slice", []int{}, new([]int)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := factory.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "zero", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(tt.value, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			// the zero value is a hit, not a miss.
+			if err := f.Get(tt.dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !f.IsCached() {
+				t.Errorf("%#v", f.IsCached())
+			}
+
+			if got := reflect.ValueOf(tt.dst).Elem(); !(got.IsZero() || got.Kind() == reflect.Slice && got.Len() == 0) {
+				t.Errorf("%#v is not zero", got.Interface())
+			}
+		})
+	}
+}
+
+func TestGetStructWithInterface(t *testing.T) {
+	before()
+
+	e := testStructInterface{V: testConcrete{A: 1, B: "b"}}
+	var dst testStructInterface
+
+	f := factory.NewFetcher()
+	f.GobRegister(testConcrete{})
+	if err := f.SetKey([]string{"prefix", "key"}, "interface"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	before()
+
+	var keys []string
+	for i, e := range []testConcrete{{A: 1, B: "a"}, {A: 2, B: "b"}} {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "many", i); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set(e, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+		keys = append(keys, f.Key())
+	}
+
+	f := factory.NewFetcher()
+	got, err := f.GetMany(append(keys, "missing"), func(key string) interface{} {
+		return &testConcrete{}
+	})
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := map[string]interface{}{
+		keys[0]: &testConcrete{A: 1, B: "a"},
+		keys[1]: &testConcrete{A: 2, B: "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	// the client does not implement MultiGetter.
+	f = cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	got, err = f.GetMany(append(keys, "missing"), func(key string) interface{} {
+		return &testConcrete{}
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v, %#v", got, err)
+	}
+}
+
+func TestDelKeys(t *testing.T) {
+	before()
+
+	var keys []string
+	for _, e := range []string{"a", "b", "c"} {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set(e, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+		keys = append(keys, f.Key())
+	}
+
+	f := factory.NewFetcher()
+	if err := f.DelKeys([]string{keys[0], keys[1], "missing"}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	got, err := f.Scan("prefix_key")
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := []string{"prefix_key_c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+}
+
+func TestDelByPattern(t *testing.T) {
+	before()
+
+	for _, e := range []interface{}{[]string{"1", "session", "a"}, []string{"2", "session", "b"}, []string{"3", "profile", "c"}} {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"user"}, e); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	f := factory.NewFetcher()
+	n, err := f.DelByPattern("user_*_session_?")
+	if err != nil || n != 2 {
+		t.Errorf("%#v, %#v", err, n)
+	}
+
+	got, err := f.Scan("user")
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := []string{"user_3_profile_c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	// the full scan.
+	for _, p := range []string{"*", "**", "", "?*", "*?", "[a-z]*", `[\]]*`, "[abc"} {
+		if n, err := f.DelByPattern(p); !errors.Is(err, cachefetcher.ErrFullScan) || n != 0 {
+			t.Errorf("%#v: %#v, %#v", p, err, n)
+		}
+	}
+
+	af := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{AllowFullScan: true}).NewFetcher()
+	if n, err := af.DelByPattern("*"); err != nil || n != 1 {
+		t.Errorf("%#v, %#v", err, n)
+	}
+
+	// the glob characters of KeyPrefix are literal, so the other namespace survives.
+	for _, prefix := range []string{"ns*", "nsx"} {
+		f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{KeyPrefix: prefix}).NewFetcher()
+		if err := f.SetKey([]string{"user"}, "a"); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	pf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{KeyPrefix: "ns*"}).NewFetcher()
+	if n, err := pf.DelByPattern("user_*"); err != nil || n != 1 {
+		t.Errorf("%#v, %#v", err, n)
+	}
+
+	if n := redisClient.Rdb.Exists(ctx, "nsx_user_a").Val(); n != 1 {
+		t.Errorf("%#v", n)
+	}
+}
+
+// vanishingScanClient is a test client whose first scanned key expires between SCAN and DEL.
+type vanishingScanClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+}
+
+func (c *vanishingScanClient) Scan(match string) ([]string, error) {
+	keys, err := c.SimpleRedisClientImpl.Scan(match)
+	if len(keys) > 0 {
+		c.Rdb.Del(ctx, keys[0])
+	}
+	return keys, err
+}
+
+func TestDelByPatternCountsDeleted(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(&vanishingScanClient{redisClient}, nil).NewFetcher()
+	for _, e := range []string{"a", "b", "c"} {
+		if err := f.SetKey([]string{"user"}, e); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	if n, err := f.DelByPattern("user_?"); err != nil || n != 2 {
+		t.Errorf("%#v, %#v", err, n)
+	}
+}
+
+func TestDelIfEquals(t *testing.T) {
+	before()
+
+	e := &testConcrete{A: 1, B: "b"}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "delifequals"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ok, err := f.DelIfEquals(&testConcrete{A: 2, B: "b"}); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	if ok, err := f.Exists(); err != nil || !ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	if ok, err := f.DelIfEquals(e); err != nil || !ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	if ok, err := f.Exists(); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	// the client does not implement CompareAndDeleter.
+	f = cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if _, err := f.DelIfEquals(e); !errors.Is(err, cachefetcher.ErrNotCompareAndDeleter) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestGobTypes(t *testing.T) {
+	before()
+
+	// registered with the other name.
+	gob.RegisterName("conflict", testGobConflictType{})
+
+	options := &cachefetcher.Options{GobTypes: []interface{}{testGobType{}, testGobConflictType{}}}
+	_ = cachefetcher.NewFactory(redisClient, options)
+	tf := cachefetcher.NewFactory(redisClient, options) // idempotent.
+
+	e := testStructInterface{V: testGobType{A: 1}}
+	var dst testStructInterface
+
+	f := tf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "gobtypes"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestDel(t *testing.T) {
+	before()
+
 	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+	if err := f.SetKey([]string{"prefix", "key"}, "hoge", "fuga"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Set(e, 10*time.Second); err != nil {
+	if err := f.Set("value", 10*time.Second); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Get(&dst); err != nil {
-		t.Errorf("%#v", err)
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
 	}
 
-	if !f.IsCached() {
+	// the value no longer exists after the successful delete.
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if f.IsCached() {
 		t.Errorf("%#v", f.IsCached())
 	}
 
-	if dst != e {
-		t.Errorf("%#v is not %#v", dst, e)
+	var dst string
+	if err := f.Get(&dst); err != nil && !errors.Is(err, redis.Nil) {
+		t.Errorf("%#v", err)
+	}
+	if dst != "" {
+		t.Errorf("%#v is not %#v", dst, "")
 	}
 }
 
-func TestGetSlice(t *testing.T) {
+func TestEmptyKey(t *testing.T) {
 	before()
 
-	e := []string{"a", "b", "c"}
-	var dst []string
-
+	// SetKey is not called.
 	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+	if err := f.Set("value", 10*time.Second); !errors.Is(err, cachefetcher.ErrEmptyKey) {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Set(e, 10*time.Second); err != nil {
+	var dst string
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrEmptyKey) {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Get(&dst); err != nil {
+	called := false
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		called = true
+		return "value", nil
+	}); !errors.Is(err, cachefetcher.ErrEmptyKey) || called {
+		t.Errorf("%#v, %#v", err, called)
+	}
+
+	if err := f.Del(); !errors.Is(err, cachefetcher.ErrEmptyKey) {
 		t.Errorf("%#v", err)
 	}
 
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+	if n := redisClient.Rdb.Exists(ctx, "").Val(); n != 0 {
+		t.Errorf("%#v", n)
 	}
 
-	if !reflect.DeepEqual(dst, e) {
-		t.Errorf("%#v is not %#v", dst, e)
+	// every operation of the fetcher's key is checked before the client.
+	client := cachefetcher.NewFakeClient()
+	ef := cachefetcher.NewFactory(client, nil).NewFetcher()
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"GetOrSet", func() error {
+			_, err := ef.GetOrSet(10*time.Second, &dst, func() (string, error) { return "value", nil })
+			return err
+		}},
+		{"SetString", func() error { return ef.SetString("value", 10*time.Second) }},
+		{"SetBytes", func() error { return ef.SetBytes([]byte("value"), 10*time.Second) }},
+		{"GetString", func() error { _, err := ef.GetString(); return err }},
+		{"GetBytes", func() error { _, err := ef.GetBytes(); return err }},
+		{"SetAt", func() error { return ef.SetAt("value", time.Now().Add(10*time.Second)) }},
+		{"SetHash", func() error { return ef.SetHash(testConcrete{A: 1}, 10*time.Second) }},
+		{"Exists", func() error { _, err := ef.Exists(); return err }},
+		{"ForceSet", func() error { return ef.ForceSet("value", 10*time.Second) }},
+		{"GetInto", func() error { return ef.GetInto(&dst) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, cachefetcher.ErrEmptyKey) {
+				t.Errorf("%#v", err)
+			}
+		})
+	}
+
+	if calls := client.Calls(); len(calls) != 0 {
+		t.Errorf("%#v", calls)
+	}
+
+	// FakeClient is not Streamer.
+	if err := f.SetReader(strings.NewReader("value"), 5, 10*time.Second); !errors.Is(err, cachefetcher.ErrEmptyKey) {
+		t.Errorf("%#v", err)
+	}
+
+	// the write is not enqueued with AsyncWrite.
+	af := cachefetcher.NewFactory(client, &cachefetcher.Options{AsyncWrite: true}).NewFetcher()
+	if err := af.Set("value", 10*time.Second); !errors.Is(err, cachefetcher.ErrEmptyKey) {
+		t.Errorf("%#v", err)
+	}
+	af.Flush()
+	if calls := client.Calls(); len(calls) != 0 {
+		t.Errorf("%#v", calls)
 	}
 }
 
-func TestGetMap(t *testing.T) {
+func TestExists(t *testing.T) {
 	before()
 
-	e := map[int]string{1: "a", 2: "b", 3: "c"}
-	var dst map[int]string
-
 	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, "map"); err != nil {
+	if err := f.SetKey([]string{"prefix", "key"}, "exists"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Set(e, 10*time.Second); err != nil {
+	ok, err := f.Exists()
+	if err != nil {
 		t.Errorf("%#v", err)
 	}
+	if ok {
+		t.Errorf("%#v", ok)
+	}
 
-	if err := f.Get(&dst); err != nil {
+	if err := f.Set("value", 10*time.Second); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+	ok, err = f.Exists()
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+	if !ok {
+		t.Errorf("%#v", ok)
 	}
 
-	if !reflect.DeepEqual(dst, e) {
-		t.Errorf("%#v is not %#v", dst, e)
+	// the client error is passed to the hook like the other methods.
+	client := cachefetcher.NewFakeClient()
+	client.FailOn("Exists", "", errDown)
+	var events []cachefetcher.Event
+	ef := cachefetcher.NewFactory(client, &cachefetcher.Options{
+		DebugPrintHook: func(e cachefetcher.Event) { events = append(events, e) },
+	}).NewFetcher()
+	if err := ef.SetKey([]string{"prefix", "key"}, "exists"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ok, err := ef.Exists(); !errors.Is(err, errDown) || ok {
+		t.Errorf("%#v, %#v", err, ok)
+	}
+	if len(events) != 1 || !errors.Is(events[0].Err, errDown) || !strings.HasSuffix(events[0].Op, ".Exists") {
+		t.Errorf("%#v", events)
 	}
 }
 
-func TestGetFailed(t *testing.T) {
+func TestPing(t *testing.T) {
 	before()
 
-	e := "a"
-	var dst int
-
 	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, e); err != nil {
+	if err := f.Ping(ctx); err != nil {
 		t.Errorf("%#v", err)
 	}
+}
 
-	if err := f.Set(e, 10*time.Second); err != nil {
+// closingClient is a test Closer that records Close.
+type closingClient struct {
+	cachefetcher.Client
+	closed bool
+}
+
+func (c *closingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	before()
+
+	c := &closingClient{Client: redisClient}
+	if err := cachefetcher.NewFactory(c, nil).NewFetcher().Close(); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrGobSerialized) {
+	if !c.closed {
+		t.Errorf("client is not closed")
+	}
+
+	// no-op for the client without Close.
+	if err := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher().Close(); err != nil {
 		t.Errorf("%#v", err)
 	}
 }
 
-func TestGetStruct(t *testing.T) {
+func TestPeekRaw(t *testing.T) {
 	before()
 
-	i := 10
-	b := true
-	s := "abc"
-	ft := 0.123
-	i8 := int8(20)
-	i64 := int64(30)
-	ui8 := uint8(40)
-	ui64 := uint64(50)
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "peek"); err != nil {
+		t.Errorf("%#v", err)
+	}
 
-	e := &testStruct{
-		I:     i,
-		B:     b,
-		S:     s,
-		F:     ft,
-		I8:    i8,
-		I64:   i64,
-		UI8:   ui8,
-		UI64:  ui64,
-		IP:    &i,
-		BP:    &b,
-		SP:    &s,
-		FP:    &ft,
-		I8P:   &i8,
-		I64P:  &i64,
-		UI8P:  &ui8,
-		UI64P: &ui64,
-		INil:  nil,
-		SNil:  nil,
-		NullS: sql.NullString{String: s, Valid: true},
-		IS:    []int{i, i, i},
-		BS:    []bool{b, b, b},
-		SS:    []string{s, s, s},
-		FS:    []float64{ft, ft, ft},
-		IM:    map[int]int{1: i, 2: i, 3: i},
-		BM:    map[bool]bool{true: b, false: b},
-		SM:    map[string]string{"a": s, "bb": s, "ccc": s},
-		FM:    map[float64]float64{0.1: ft, 0.2: ft, 0.3: ft},
+	if s, ok, err := f.PeekRaw(); err != nil || ok || s != "" {
+		t.Errorf("%#v, %#v, %#v", s, ok, err)
 	}
 
-	var dst testStruct
+	if err := f.Set(&testConcrete{A: 1, B: "a"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	s, ok, err := f.PeekRaw()
+	if err != nil || !ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	// the on-wire gob bytes.
+	if want := redisClient.Rdb.Get(ctx, f.Key()).Val(); s != want {
+		t.Errorf("%#v is not %#v", s, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	before()
+
+	for _, e := range []string{"a", "b", "c"} {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"product", "key"}, e); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set(e, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
 
 	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, "struct1"); err != nil {
+	if err := f.SetKey([]string{"other", "key"}); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Set(e, 10*time.Second); err != nil {
+	if err := f.Set("other", 10*time.Second); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	err := f.Get(&dst)
+	keys, err := f.Scan("product_")
 	if err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+	sort.Strings(keys)
+	want := []string{"product_key_a", "product_key_b", "product_key_c"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("%#v is not %#v", keys, want)
 	}
+}
 
-	if !reflect.DeepEqual(dst, *e) {
-		t.Errorf("%#v is not %#v", dst, e)
+func TestTier(t *testing.T) {
+	before()
+
+	var events []cachefetcher.Event
+	tf := cachefetcher.NewFactory(&tieredClient{SimpleRedisClientImpl: redisClient, l1: map[string]string{}}, &cachefetcher.Options{
+		DebugPrintHook: func(e cachefetcher.Event) {
+			events = append(events, e)
+		},
+	})
+
+	f := tf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "tier"); err != nil {
+		t.Errorf("%#v", err)
 	}
 
-	el := []testStruct{*e, *e}
-	var dstList []testStruct
+	var dst string
+	for i := 0; i < 3; i++ {
+		if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+			return "value", nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
 
-	f2 := factory.NewFetcher()
-	if err := f2.SetKey([]string{"prefix", "key"}, "struct2"); err != nil {
+	var got []cachefetcher.Tier
+	for _, e := range events {
+		got = append(got, e.Tier)
+	}
+
+	want := []cachefetcher.Tier{cachefetcher.TierMiss, cachefetcher.TierL2, cachefetcher.TierL1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+}
+
+type tenantKey struct{}
+
+func TestBypassFunc(t *testing.T) {
+	before()
+
+	type bypassKey struct{}
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		BypassFunc: func(ctx context.Context) bool {
+			b, _ := ctx.Value(bypassKey{}).(bool)
+			return b
+		},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "bypass"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f2.Set(el, 10*time.Second); err != nil {
+	if err := f.Set("old", 10*time.Second); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f2.Get(&dstList); err != nil {
+	// the bypass calls the fetcher, and refreshes the cache.
+	var dst string
+	bctx := context.WithValue(ctx, bypassKey{}, true)
+	if err := f.FetchWithContext(bctx, 10*time.Second, &dst, func() (string, error) { return "new", nil }); err != nil {
 		t.Errorf("%#v", err)
 	}
+	if dst != "new" || f.IsCached() {
+		t.Errorf("%#v, %#v", dst, f.IsCached())
+	}
 
-	if !f2.IsCached() {
-		t.Errorf("%#v", f2.IsCached())
+	if err := f.FetchWithContext(ctx, 10*time.Second, &dst, func() (string, error) { return "other", nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if dst != "new" || !f.IsCached() {
+		t.Errorf("%#v, %#v", dst, f.IsCached())
 	}
 
-	if !reflect.DeepEqual(dstList, el) {
-		t.Errorf("%#v is not %#v", dstList, el)
+	// the fetcher error is returned without touching the cache.
+	errFetch := errors.New("fetch")
+	if err := f.FetchWithContext(bctx, 10*time.Second, &dst, func() (string, error) { return "", errFetch }); !errors.Is(err, errFetch) {
+		t.Errorf("%#v", err)
 	}
+	if err := f.Get(&dst); err != nil || dst != "new" {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
 
-	e2 := &testStruct2{P: e}
-	var dst2 testStruct2
+func TestGroupKeyFromContext(t *testing.T) {
+	before()
 
-	f3 := factory.NewFetcher()
-	if err := f3.SetKey([]string{"prefix", "key"}, "struct3"); err != nil {
+	gf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		Group:          &singleflight.Group{},
+		GroupKeyPrefix: "svcA",
+		GroupKeyFromContext: func(ctx context.Context) string {
+			s, _ := ctx.Value(tenantKey{}).(string)
+			return s
+		},
+	})
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, tenant := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(tenant string) {
+			defer wg.Done()
+
+			f := gf.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "tenant"); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst string
+			tctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+			if err := f.FetchWithContext(tctx, 10*time.Second, &dst, func() (string, error) {
+				started <- tenant
+				<-release
+				return tenant, nil
+			}); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if dst != tenant {
+				t.Errorf("%#v is not %#v", dst, tenant)
+			}
+		}(tenant)
+	}
+
+	// both tenants call the fetcher without coalescing.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Errorf("coalesced across tenants")
+		}
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestSetGroupKey(t *testing.T) {
+	before()
+
+	gf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Group: &singleflight.Group{}})
+
+	var calls int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	fetch := func(requestID string, wg *sync.WaitGroup) {
+		defer wg.Done()
+
+		f := gf.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "group", requestID); err != nil {
+			t.Errorf("%#v", err)
+		}
+		f.SetGroupKey("prefix_key_group")
+
+		var dst string
+		if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+			atomic.AddInt32(&calls, 1)
+			started <- struct{}{}
+			<-release
+			return "value", nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if dst != "value" {
+			t.Errorf("%#v is not value", dst)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go fetch("req1", &wg)
+	<-started
+
+	go fetch("req2", &wg)
+	time.Sleep(50 * time.Millisecond) // wait for joining the call.
+	close(release)
+	wg.Wait()
+
+	// coalesced on the group key across the storage keys.
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%#v is not 1", n)
+	}
+}
+
+func TestClone(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "clone"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f3.Set(e2, 10*time.Second); err != nil {
+	if err := f.Set("value", 10*time.Second); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f3.Get(&dst2); err != nil {
+	c := f.Clone()
+	if c.Key() != "" || c.IsCached() {
+		t.Errorf("%#v, %#v", c.Key(), c.IsCached())
+	}
+
+	if err := c.SetKey([]string{"prefix", "key"}, "clone"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
-	if !f3.IsCached() {
-		t.Errorf("%#v", f3.IsCached())
+	var dst string
+	if err := c.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
 	}
 
-	if !reflect.DeepEqual(dst2, *e2) {
-		t.Errorf("%#v is not %#v", dst2, e2)
+	if dst != "value" {
+		t.Errorf("%#v is not %#v", dst, "value")
+	}
+
+	if err := c.SetKey([]string{"prefix", "key"}, "other"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.Key() == c.Key() {
+		t.Errorf("%#v", f.Key())
 	}
 }
 
-func TestDel(t *testing.T) {
+func TestClock(t *testing.T) {
 	before()
 
-	f := factory.NewFetcher()
-	if err := f.SetKey([]string{"prefix", "key"}, "hoge", "fuga"); err != nil {
+	var events []cachefetcher.Event
+	clock := &fakeClock{now: time.Unix(0, 0), step: time.Second}
+	tf := cachefetcher.NewFactory(&slowClient{SimpleRedisClientImpl: redisClient, delay: time.Second}, &cachefetcher.Options{
+		Clock: clock,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			events = append(events, e)
+		},
+	})
+
+	f := tf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "clock"); err != nil {
 		t.Errorf("%#v", err)
 	}
 
@@ -635,18 +3389,37 @@ func TestDel(t *testing.T) {
 		t.Errorf("%#v", err)
 	}
 
-	if err := f.Del(); err != nil {
+	if len(events) != 1 || events[0].Elapsed != time.Second {
+		t.Errorf("%#v", events)
+	}
+
+	// GroupTimeout fires without waiting.
+	var dst string
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrTimeout) {
 		t.Errorf("%#v", err)
 	}
-	if !f.IsCached() {
-		t.Errorf("%#v", f.IsCached())
+}
+
+func TestClientTimeout(t *testing.T) {
+	before()
+
+	tf := cachefetcher.NewFactory(&slowClient{SimpleRedisClientImpl: redisClient, delay: time.Second}, &cachefetcher.Options{
+		ClientTimeout: 50 * time.Millisecond,
+	})
+
+	f := tf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "timeout"); err != nil {
+		t.Errorf("%#v", err)
 	}
 
 	var dst string
-	if err := f.Get(&dst); err != nil && !errors.Is(err, redis.Nil) {
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		return "piyo", nil
+	}); !errors.Is(err, cachefetcher.ErrClientTimeout) {
 		t.Errorf("%#v", err)
 	}
-	if dst != "" {
-		t.Errorf("%#v is not %#v", dst, "")
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
 	}
 }
@@ -0,0 +1,51 @@
+package cachefetcher
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Clock is the time source. Options.Clock replaces the real clock, e.g. with the fake clock for deterministic tests.
+	Clock interface {
+		Now() time.Time
+		After(d time.Duration) <-chan time.Time
+
+		// Timer is After that returns stop to release the timer before it fires.
+		// stop must be called once, and the channel must not be received after stop.
+		Timer(d time.Duration) (c <-chan time.Time, stop func())
+	}
+
+	realClock struct{}
+)
+
+var (
+	defaultClock Clock = realClock{}
+
+	// timerPool reuses the stopped timers, so that the timeout of each call does not allocate a timer.
+	timerPool sync.Pool
+)
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) Timer(d time.Duration) (<-chan time.Time, func()) {
+	t, ok := timerPool.Get().(*time.Timer)
+	if ok {
+		t.Reset(d)
+	} else {
+		t = time.NewTimer(d)
+	}
+
+	return t.C, func() {
+		if !t.Stop() {
+			// drain the fired value not received, so that the reused timer does not fire immediately.
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		timerPool.Put(t)
+	}
+}
@@ -0,0 +1,197 @@
+package cachefetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses the stored value.
+// ID is stored in the value header, so that get picks the right decompressor.
+type Compressor interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+	ID() byte
+}
+
+// GzipCompressor is gzip Compressor.
+type GzipCompressor struct {
+	Level int // gzip.DefaultCompression if 0.
+}
+
+// ZstdCompressor is zstd Compressor. It has the better ratio than gzip.
+// The encoder and the decoder are created once and reused.
+type ZstdCompressor struct {
+	Level zstd.EncoderLevel // zstd.SpeedDefault if 0.
+
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+// SnappyCompressor is snappy Compressor. It is faster than gzip, but the ratio is lower.
+type SnappyCompressor struct{}
+
+const (
+	// CompressorIDGzip is GzipCompressor's ID.
+	CompressorIDGzip byte = 1
+	// CompressorIDZstd is ZstdCompressor's ID.
+	CompressorIDZstd byte = 2
+	// CompressorIDSnappy is SnappyCompressor's ID.
+	CompressorIDSnappy byte = 3
+
+	// compressMagic never starts a gob stream.
+	compressMagic = "\x00cf"
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[byte]Compressor{
+		CompressorIDGzip:   &GzipCompressor{},
+		CompressorIDZstd:   &ZstdCompressor{},
+		CompressorIDSnappy: &SnappyCompressor{},
+	}
+
+	// ErrCompression failed to compress or decompress.
+	ErrCompression = errors.New("cachefetcher: compression failed")
+)
+
+// RegisterCompressor registers c as the decompressor of c.ID(). gzip, zstd and snappy are registered by default.
+// Registration is process-global. The decompressor of Options.Compression is registered automatically.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.ID()] = c
+}
+
+func lookupCompressor(id byte) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[id]
+	return c, ok
+}
+
+// compress returns the header "<magic><id>" and the compressed value.
+func compress(c Compressor, b []byte) (string, error) {
+	cb, err := c.Compress(b)
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrCompression, err)
+	}
+	return compressMagic + string(c.ID()) + string(cb), nil
+}
+
+// decompress returns s as is if s has no header.
+func decompress(s string) (string, error) {
+	if len(s) <= len(compressMagic) || !strings.HasPrefix(s, compressMagic) {
+		return s, nil
+	}
+
+	id := s[len(compressMagic)]
+	c, ok := lookupCompressor(id)
+	if !ok {
+		return "", fmt.Errorf("%w: unknown compressor id %d", ErrCompression, id)
+	}
+
+	b, err := c.Decompress([]byte(s[len(compressMagic)+1:]))
+	if err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrCompression, err)
+	}
+	return string(b), nil
+}
+
+// Compress is gzip compress.
+func (c *GzipCompressor) Compress(b []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress is gzip decompress.
+func (c *GzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// ID is CompressorIDGzip.
+func (c *GzipCompressor) ID() byte {
+	return CompressorIDGzip
+}
+
+func (c *ZstdCompressor) init() error {
+	c.once.Do(func() {
+		level := c.Level
+		if level == 0 {
+			level = zstd.SpeedDefault
+		}
+
+		if c.enc, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(level)); c.err != nil {
+			return
+		}
+		c.dec, c.err = zstd.NewReader(nil)
+	})
+	return c.err
+}
+
+// Compress is zstd compress.
+func (c *ZstdCompressor) Compress(b []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.enc.EncodeAll(b, nil), nil
+}
+
+// Decompress is zstd decompress.
+func (c *ZstdCompressor) Decompress(b []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.dec.DecodeAll(b, nil)
+}
+
+// ID is CompressorIDZstd.
+func (c *ZstdCompressor) ID() byte {
+	return CompressorIDZstd
+}
+
+// Compress is snappy block compress.
+func (c *SnappyCompressor) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+// Decompress is snappy block decompress.
+func (c *SnappyCompressor) Decompress(b []byte) ([]byte, error) {
+	return snappy.Decode(nil, b)
+}
+
+// ID is CompressorIDSnappy.
+func (c *SnappyCompressor) ID() byte {
+	return CompressorIDSnappy
+}
@@ -0,0 +1,148 @@
+package cachefetcher_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// reverseCompressor is a test Compressor that reverses bytes.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(b []byte) ([]byte, error)   { return reverse(b), nil }
+func (reverseCompressor) Decompress(b []byte) ([]byte, error) { return reverse(b), nil }
+func (reverseCompressor) ID() byte                            { return 100 }
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestCompression(t *testing.T) {
+	before()
+
+	e := &testStruct{I: 1, S: string(bytes.Repeat([]byte("a"), 1000)), SS: []string{"a", "b"}}
+
+	tests := []struct {
+		name       string
+		compressor cachefetcher.Compressor
+		other      cachefetcher.Compressor
+	}{
+		{"gzip", &cachefetcher.GzipCompressor{}, reverseCompressor{}},
+		{"zstd", &cachefetcher.ZstdCompressor{}, &cachefetcher.GzipCompressor{}},
+		{"snappy", &cachefetcher.SnappyCompressor{}, &cachefetcher.ZstdCompressor{}},
+		{"custom", reverseCompressor{}, &cachefetcher.SnappyCompressor{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Compression: tt.compressor})
+
+			f := cf.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(e, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst testStruct
+			if err := f.Get(&dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !reflect.DeepEqual(dst, *e) {
+				t.Errorf("%#v is not %#v", dst, e)
+			}
+
+			// read by the fetcher with the other compressor.
+			f2 := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Compression: tt.other}).NewFetcher()
+			if err := f2.SetKey([]string{"prefix", "key"}, tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst2 testStruct
+			if err := f2.Get(&dst2); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !reflect.DeepEqual(dst2, *e) {
+				t.Errorf("%#v is not %#v", dst2, e)
+			}
+		})
+	}
+}
+
+func TestCompressionGzipSize(t *testing.T) {
+	before()
+
+	e := bytes.Repeat([]byte("a"), 1000)
+
+	cf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Compression: &cachefetcher.GzipCompressor{}})
+
+	f := cf.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "size"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if n := len(redisClient.Rdb.Get(ctx, f.Key()).Val()); n >= len(e) {
+		t.Errorf("%#v is not compressed", n)
+	}
+
+	var dst []byte
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !reflect.DeepEqual(dst, e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Compression: &cachefetcher.GzipCompressor{}}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "unknown"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	redisClient.Rdb.Set(ctx, f.Key(), "\x00cf\xfevalue", 10*time.Second)
+
+	var dst string
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrCompression) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestCompressionPrefixWithoutOption(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "prefix"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the raw value like the compressed value is not decompressed without Compression.
+	e := []byte("\x00cf\xfevalue")
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst []byte
+	if err := f.Get(&dst); err != nil || !bytes.Equal(dst, e) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
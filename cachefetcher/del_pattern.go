@@ -0,0 +1,89 @@
+package cachefetcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// delBatchSize is the number of the keys deleted at once by DelByPattern.
+const delBatchSize = 1000
+
+// ErrFullScan is DelByPattern's pattern matches all keys without Options.AllowFullScan.
+var ErrFullScan = errors.New("cachefetcher: pattern matches all keys")
+
+// DelByPattern deletes the keys matching the glob pattern, e.g. "user_*_session_?", and returns the number of the deleted keys.
+// The key expired or deleted between SCAN and DEL is not counted.
+// Options.KeyPrefix is prepended to pattern. The keys are listed with SCAN MATCH, and deleted in batches.
+// It is eventually-consistent like Scan: the key written during the scan may survive.
+// The pattern without the literal character, e.g. "*" or "?*", returns ErrFullScan without Options.AllowFullScan.
+// KeyPrefix is escaped, so the glob characters in it are matched literally.
+func (f *cacheFetcherImpl) DelByPattern(pattern string) (int, error) {
+	start := f.options.Clock.Now()
+	n, err := f.delByPattern(pattern)
+	if err != nil {
+		return n, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (f *cacheFetcherImpl) delByPattern(pattern string) (int, error) {
+	if !hasGlobLiteral(pattern) && !f.options.AllowFullScan {
+		return 0, fmt.Errorf("%w: %q", ErrFullScan, pattern)
+	}
+
+	if f.options.KeyPrefix != "" {
+		pattern = globEscaper.Replace(f.options.KeyPrefix) + sep + pattern
+	}
+
+	var keys []string
+	err := f.withKeysClientTimeout(func() (err error) {
+		keys, err = f.client.Scan(pattern)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > delBatchSize {
+			batch = batch[:delBatchSize]
+		}
+		keys = keys[len(batch):]
+
+		var deleted int64
+		err := f.withKeysClientTimeout(func() (err error) {
+			deleted, err = f.client.DelMulti(batch)
+			return err
+		})
+		if f.isErrOtherThanCacheMiss(err) {
+			return n, err
+		}
+		n += int(deleted)
+	}
+	return n, nil
+}
+
+// hasGlobLiteral reports whether the glob pattern has the literal character, which is not "*", "?" or the class "[...]".
+// The escaped character, e.g. \*, is literal. The unclosed class matches to the end as Redis does.
+func hasGlobLiteral(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package cachefetcher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrExpireAtPast is the expireAt of SetAt and FetchAt is not after now.
+var ErrExpireAtPast = errors.New("cachefetcher: expire at is in the past")
+
+// SetAt is Set that expires at expireAt of the wall clock, e.g. at midnight UTC regardless of when it is written.
+// The expiration is computed from Options.Clock's now. expireAt not after now returns ErrExpireAtPast.
+func (f *cacheFetcherImpl) SetAt(value interface{}, expireAt time.Time) error {
+	start := f.options.Clock.Now()
+	expiration, err := f.expirationUntil(expireAt)
+	if err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.set(value, expiration, false); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FetchAt is Fetch that sets the cache to expire at expireAt of the wall clock.
+// expireAt not after now returns ErrExpireAtPast without calling the fetcher.
+func (f *cacheFetcherImpl) FetchAt(expireAt time.Time, dst interface{}, fetcher interface{}) error {
+	expiration, err := f.expirationUntil(expireAt)
+	if err != nil {
+		return err
+	}
+	return f.Fetch(expiration, dst, fetcher)
+}
+
+// expirationUntil returns the expiration from now to expireAt.
+func (f *cacheFetcherImpl) expirationUntil(expireAt time.Time) (time.Duration, error) {
+	d := expireAt.Sub(f.options.Clock.Now())
+	if d <= 0 {
+		return 0, fmt.Errorf("%w: %v", ErrExpireAtPast, expireAt)
+	}
+	return d, nil
+}
@@ -0,0 +1,86 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// expirationClient is a test client that records the expiration of the last Set.
+type expirationClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	expiration time.Duration
+}
+
+func (c *expirationClient) Set(key string, value interface{}, expiration time.Duration) error {
+	c.expiration = expiration
+	return c.SimpleRedisClientImpl.Set(key, value, expiration)
+}
+
+func TestSetAt(t *testing.T) {
+	before()
+
+	client := &expirationClient{SimpleRedisClientImpl: redisClient}
+	clock := &manualClock{now: time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC)}
+	f := cachefetcher.NewFactory(client, &cachefetcher.Options{Clock: clock}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "setat"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	midnight := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := f.SetAt("value", midnight); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if client.expiration != time.Hour {
+		t.Errorf("%#v is not %#v", client.expiration, time.Hour)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil || dst != "value" {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	// the past and now.
+	for _, at := range []time.Time{midnight.Add(-2 * time.Hour), clock.Now()} {
+		if err := f.SetAt("value", at); !errors.Is(err, cachefetcher.ErrExpireAtPast) {
+			t.Errorf("%#v", err)
+		}
+	}
+}
+
+func TestFetchAt(t *testing.T) {
+	before()
+
+	client := &expirationClient{SimpleRedisClientImpl: redisClient}
+	clock := &manualClock{now: time.Date(2021, 1, 1, 23, 30, 0, 0, time.UTC)}
+	f := cachefetcher.NewFactory(client, &cachefetcher.Options{Clock: clock}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "fetchat"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	midnight := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	var dst string
+	if err := f.FetchAt(midnight, &dst, func() (string, error) { return "fetched", nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if client.expiration != 30*time.Minute || dst != "fetched" {
+		t.Errorf("%#v, %#v", client.expiration, dst)
+	}
+
+	called := false
+	clock.advance(time.Hour)
+	if err := f.FetchAt(midnight, &dst, func() (string, error) {
+		called = true
+		return "fetched", nil
+	}); !errors.Is(err, cachefetcher.ErrExpireAtPast) {
+		t.Errorf("%#v", err)
+	}
+
+	if called {
+		t.Errorf("the fetcher is called")
+	}
+}
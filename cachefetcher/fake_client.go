@@ -0,0 +1,320 @@
+package cachefetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// FakeClient is the deterministic in-memory Client for the tests of the fetchers, without Redis.
+	// The values are preset by Preset, the errors are forced by FailOn, and the calls are recorded.
+	// The expiration is recorded but the values never expire.
+	FakeClient struct {
+		// ErrMiss is the cache miss error of Get, HGetAll and HGet. default is ErrFakeMiss.
+		ErrMiss error
+
+		mu     sync.Mutex
+		values map[string]string
+		hashes map[string]map[string]string
+		errs   map[FakeCall]error
+		calls  []FakeCall
+	}
+
+	// FakeCall is the recorded call of FakeClient. The call of the multiple keys is recorded by key.
+	FakeCall struct {
+		Op         string // the method name, e.g. "Get".
+		Key        string
+		Expiration time.Duration
+	}
+)
+
+// ErrFakeMiss is the default cache miss error of FakeClient.
+var ErrFakeMiss = errors.New("cachefetcher: fake cache miss")
+
+// NewFakeClient returns the empty FakeClient.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		values: map[string]string{},
+		hashes: map[string]map[string]string{},
+		errs:   map[FakeCall]error{},
+	}
+}
+
+// Preset sets the stored value of key without recording the call.
+// value is the stored value, e.g. the string of SetString, or the value encoded by the fetcher's Set.
+func (c *FakeClient) Preset(key string, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+}
+
+// FailOn forces op of key to return err. The empty key matches all keys. The nil err removes it.
+func (c *FakeClient) FailOn(op, key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := FakeCall{Op: op, Key: key}
+	if err == nil {
+		delete(c.errs, k)
+		return
+	}
+	c.errs[k] = err
+}
+
+// Calls returns the recorded calls in order.
+func (c *FakeClient) Calls() []FakeCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]FakeCall(nil), c.calls...)
+}
+
+// Reset removes the values, the forced errors and the recorded calls.
+func (c *FakeClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = map[string]string{}
+	c.hashes = map[string]map[string]string{}
+	c.errs = map[FakeCall]error{}
+	c.calls = nil
+}
+
+// call records the call, and returns the forced error. It must be called with the lock.
+func (c *FakeClient) call(op, key string, expiration time.Duration) error {
+	c.calls = append(c.calls, FakeCall{Op: op, Key: key, Expiration: expiration})
+	if err, ok := c.errs[FakeCall{Op: op, Key: key}]; ok {
+		return err
+	}
+	return c.errs[FakeCall{Op: op}]
+}
+
+func (c *FakeClient) miss() error {
+	if c.ErrMiss != nil {
+		return c.ErrMiss
+	}
+	return ErrFakeMiss
+}
+
+// fakeString returns the stored string of value like Redis.
+func fakeString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// Set is Client's Set.
+func (c *FakeClient) Set(key string, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("Set", key, expiration); err != nil {
+		return err
+	}
+
+	c.values[key] = fakeString(value)
+	return nil
+}
+
+// SetNX is Client's SetNX.
+func (c *FakeClient) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SetNX", key, expiration); err != nil {
+		return false, err
+	}
+
+	if _, ok := c.values[key]; ok {
+		return false, nil
+	}
+	c.values[key] = fakeString(value)
+	return true, nil
+}
+
+// Get is Client's Get.
+func (c *FakeClient) Get(key string, dst interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("Get", key, 0); err != nil {
+		return err
+	}
+
+	v, ok := c.values[key]
+	if !ok {
+		return c.miss()
+	}
+	reflect.ValueOf(dst).Elem().SetString(v)
+	return nil
+}
+
+// MGet is Client's MGet. It returns only the existing keys.
+func (c *FakeClient) MGet(keys []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := map[string]string{}
+	for _, k := range keys {
+		if err := c.call("MGet", k, 0); err != nil {
+			return nil, err
+		}
+		if v, ok := c.values[k]; ok {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+// Del is Client's Del.
+func (c *FakeClient) Del(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("Del", key, 0); err != nil {
+		return err
+	}
+
+	delete(c.values, key)
+	delete(c.hashes, key)
+	return nil
+}
+
+// DelMulti is Client's DelMulti.
+func (c *FakeClient) DelMulti(keys []string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		if err := c.call("DelMulti", k, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int64
+	for _, k := range keys {
+		_, ok := c.values[k]
+		_, hok := c.hashes[k]
+		if ok || hok {
+			n++
+		}
+		delete(c.values, k)
+		delete(c.hashes, k)
+	}
+	return n, nil
+}
+
+// Exists is Client's Exists.
+func (c *FakeClient) Exists(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("Exists", key, 0); err != nil {
+		return false, err
+	}
+
+	_, ok := c.values[key]
+	_, hok := c.hashes[key]
+	return ok || hok, nil
+}
+
+// Scan is Client's Scan. match is the glob pattern, and the keys are sorted.
+func (c *FakeClient) Scan(match string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("Scan", match, 0); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range c.values {
+		if ok, _ := path.Match(match, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range c.hashes {
+		if ok, _ := path.Match(match, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// HSet is Client's HSet. It replaces the whole hash with fields.
+func (c *FakeClient) HSet(key string, fields map[string]string, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("HSet", key, expiration); err != nil {
+		return err
+	}
+
+	h := make(map[string]string, len(fields))
+	for f, v := range fields {
+		h[f] = v
+	}
+	c.hashes[key] = h
+	return nil
+}
+
+// HGetAll is Client's HGetAll. The missing hash is the cache miss, as SimpleRedisClientImpl.
+func (c *FakeClient) HGetAll(key string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("HGetAll", key, 0); err != nil {
+		return nil, err
+	}
+	if len(c.hashes[key]) == 0 {
+		return nil, c.miss()
+	}
+
+	h := make(map[string]string, len(c.hashes[key]))
+	for f, v := range c.hashes[key] {
+		h[f] = v
+	}
+	return h, nil
+}
+
+// HGet is Client's HGet.
+func (c *FakeClient) HGet(key, field string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("HGet", key, 0); err != nil {
+		return "", err
+	}
+
+	v, ok := c.hashes[key][field]
+	if !ok {
+		return "", c.miss()
+	}
+	return v, nil
+}
+
+// Expire is Expirer's Expire. The expiration is only recorded.
+func (c *FakeClient) Expire(key string, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.call("Expire", key, expiration)
+}
+
+// Ping is Pinger's Ping.
+func (c *FakeClient) Ping(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.call("Ping", "", 0)
+}
+
+// Close is Closer's Close.
+func (c *FakeClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.call("Close", "", 0)
+}
+
+// IsErrCacheMiss is Client's IsErrCacheMiss with ErrMiss.
+func (c *FakeClient) IsErrCacheMiss(err error) bool {
+	return errors.Is(err, c.miss())
+}
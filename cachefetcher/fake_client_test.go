@@ -0,0 +1,127 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestFakeClient(t *testing.T) {
+	client := cachefetcher.NewFakeClient()
+	f := cachefetcher.NewFactory(client, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "fake"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// miss and hit.
+	for i, want := range []bool{false, true} {
+		var dst testConcrete
+		if err := f.Fetch(10*time.Second, &dst, func() (testConcrete, error) {
+			return testConcrete{A: 1, B: "b"}, nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if f.IsCached() != want {
+			t.Errorf("%d: %#v is not %#v", i, f.IsCached(), want)
+		}
+	}
+
+	wantCalls := []cachefetcher.FakeCall{
+		{Op: "Get", Key: f.Key()},
+		{Op: "Set", Key: f.Key(), Expiration: 10 * time.Second},
+		{Op: "Get", Key: f.Key()},
+	}
+	if calls := client.Calls(); !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("%#v is not %#v", calls, wantCalls)
+	}
+
+	// the preset value.
+	client.Preset("preset", "value")
+	f2 := cachefetcher.NewFactory(client, nil).NewFetcher()
+	if err := f2.SetKey([]string{"preset"}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if s, err := f2.GetString(); err != nil || s != "value" {
+		t.Errorf("%#v, %#v", s, err)
+	}
+
+	client.Reset()
+	if len(client.Calls()) != 0 {
+		t.Errorf("%#v", client.Calls())
+	}
+
+	if _, err := f2.GetString(); !client.IsErrCacheMiss(err) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestFakeClientHash(t *testing.T) {
+	client := cachefetcher.NewFakeClient()
+	f := cachefetcher.NewFactory(client, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "fakehash"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the missing hash is the cache miss.
+	var dst testConcrete
+	if err := f.GetHash(&dst); !client.IsErrCacheMiss(err) {
+		t.Errorf("%#v", err)
+	}
+	if f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+
+	if err := f.SetHash(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.GetHash(&dst); err != nil || dst != (testConcrete{A: 1, B: "b"}) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+}
+
+func TestFakeClientFailOn(t *testing.T) {
+	errFailed := errors.New("failed")
+	errMiss := errors.New("miss")
+	client := cachefetcher.NewFakeClient()
+	client.ErrMiss = errMiss
+	client.FailOn("Set", "prefix_key_a", errFailed)
+
+	f := cachefetcher.NewFactory(client, nil).NewFetcher()
+	for _, tt := range []struct {
+		element string
+		err     error
+	}{{"a", errFailed}, {"b", nil}} {
+		if err := f.SetKey([]string{"prefix", "key"}, tt.element); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set("value", 10*time.Second); !errors.Is(err, tt.err) {
+			t.Errorf("%#v: %#v is not %#v", tt.element, err, tt.err)
+		}
+	}
+
+	// the empty key matches all keys.
+	client.FailOn("Get", "", errFailed)
+	var dst string
+	if err := f.Get(&dst); !errors.Is(err, errFailed) {
+		t.Errorf("%#v", err)
+	}
+
+	client.FailOn("Get", "", nil)
+	if err := f.SetKey([]string{"prefix", "key"}, "a"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Get(&dst); !errors.Is(err, errMiss) || !client.IsErrCacheMiss(err) {
+		t.Errorf("%#v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package cachefetcher
+
+import (
+	"context"
+	"time"
+)
+
+// ForceSet is Set that forgets the in-flight singleflight call of the key, e.g. for the manual cache fix.
+// Without it, the callers of Fetch joining the in-flight call get its stale result after the write,
+// so the fix looks clobbered. After ForceSet, the subsequent Fetch starts a new call and reads the written value.
+// The in-flight fetcher still sets its own result when it returns, so combine it with SetIfNewer
+// if the write must win. The group key of Options.GroupKeyFromContext is scoped by the caller's context,
+// and it is not forgotten.
+func (f *cacheFetcherImpl) ForceSet(value interface{}, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+
+	f.forget()
+	if err := f.set(value, expiration, false); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+	f.forget() // the call started during the write read the old value.
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+// forget forgets the singleflight calls of Get, GetBytes, Fetch and GetOrSet of the key.
+func (f *cacheFetcherImpl) forget() {
+	if f.options.DisableSingleflight || f.options.GroupKeyFromContext != nil {
+		return
+	}
+
+	ctx := context.Background()
+	for _, k := range []string{f.key, f.key + sep + getBytesSuffix, f.flightKey(), f.flightKey() + sep + getOrSetSuffix} {
+		f.options.Group.Forget(f.groupKey(ctx, k))
+	}
+}
@@ -0,0 +1,54 @@
+package cachefetcher_test
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForceSet(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "forceset"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan string)
+	go func() {
+		f1 := f.Clone()
+		if err := f1.SetKey([]string{"prefix", "key"}, "forceset"); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		var dst string
+		if err := f1.Fetch(10*time.Second, &dst, func() (string, error) {
+			close(started)
+			<-release
+			return "stale", nil
+		}); err != nil {
+			t.Errorf("%#v", err)
+		}
+		done <- dst
+	}()
+	<-started
+
+	// the fix during the in-flight fetch.
+	if err := f.ForceSet("fresh", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the subsequent fetch does not join the in-flight call, and reads the written value.
+	var dst string
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		t.Error("called")
+		return "", nil
+	}); err != nil || dst != "fresh" || !f.IsCached() {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	close(release)
+	if s := <-done; s != "stale" {
+		t.Errorf("%#v", s)
+	}
+}
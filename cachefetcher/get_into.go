@@ -0,0 +1,65 @@
+package cachefetcher
+
+// GetInto gets the stored value once, and decodes it into each dst, e.g. the struct and the raw JSON for ETag.
+// *string and *[]byte dst get the serialized payload without the header, the metadata and the compression,
+// e.g. the JSON text with JSONSerializer. The other dst is decoded as Get does.
+// It does not use singleflight.
+func (f *cacheFetcherImpl) GetInto(dsts ...interface{}) error {
+	start := f.options.Clock.Now()
+	if err := f.getInto(dsts); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) getInto(dsts []interface{}) error {
+	f.setCached(false)
+	f.setTier("")
+
+	for _, dst := range dsts {
+		if err := f.checkDst(dst); err != nil {
+			return err
+		}
+	}
+
+	var s string
+	if err := f.withClientTimeout(func() error { return f.clientGet(&s) }); err != nil {
+		return err
+	}
+
+	for _, dst := range dsts {
+		var err error
+		switch d := dst.(type) {
+		case *string:
+			*d, err = f.payload(s)
+		case *[]byte:
+			var p string
+			p, err = f.payload(s)
+			*d = []byte(p)
+		default:
+			err = f.decode(s, dst, false)
+		}
+		if err != nil {
+			return f.withKey(err)
+		}
+	}
+
+	f.setCached(true)
+	return nil
+}
+
+// payload returns the serialized payload of the stored value s.
+func (f *cacheFetcherImpl) payload(s string) (string, error) {
+	if !f.options.IsNotSerialized && hasFormatHeader(s) {
+		_, b, err := payloadWithHeader(s)
+		return string(b), err
+	}
+	if f.options.Compression == nil {
+		return s, nil
+	}
+	return decompress(s)
+}
@@ -0,0 +1,60 @@
+package cachefetcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestGetInto(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+	}{
+		{"json", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}}},
+		{"json gzip", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}, Compression: &cachefetcher.GzipCompressor{}}},
+		{"json header", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}, FormatHeader: true, Compression: &cachefetcher.GzipCompressor{}}},
+		{"json meta", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}, StoreMeta: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "getinto", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var (
+				v testConcrete
+				s string
+				b []byte
+			)
+			if err := f.GetInto(&v, &s, &b); err != nil || !f.IsCached() {
+				t.Errorf("%#v, %#v", err, f.IsCached())
+			}
+
+			want := `{"A":1,"B":"b"}`
+			if v != (testConcrete{A: 1, B: "b"}) || s != want || string(b) != want {
+				t.Errorf("%#v, %#v, %#v", v, s, string(b))
+			}
+		})
+	}
+
+	// the miss.
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "getinto", "missing"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var v testConcrete
+	if err := f.GetInto(&v); !redisClient.IsErrCacheMiss(err) || f.IsCached() {
+		t.Errorf("%#v", err)
+	}
+}
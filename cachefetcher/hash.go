@@ -0,0 +1,235 @@
+package cachefetcher
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ErrNoStructType is SetHash's value or GetHash's dst type is not struct.
+var ErrNoStructType = errors.New("cachefetcher: no struct type")
+
+// SetHash sets the struct value as a hash. Each exported field is a hash field named the field name.
+// string, bool, numeric and encoding.TextMarshaler fields are stored as text, so they are readable in redis-cli.
+// The other fields are stored with gob. The nil pointer field is not stored.
+func (f *cacheFetcherImpl) SetHash(value interface{}, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.setHash(value, expiration); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) setHash(value interface{}, expiration time.Duration) error {
+	f.setCached(false)
+	expiration, err := f.resolveExpiration(expiration)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("value: %w", ErrNoStructType)
+	}
+
+	fields := map[string]string{}
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported.
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			continue
+		}
+
+		s, err := encodeHashField(fv)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sf.Name, err)
+		}
+		fields[sf.Name] = s
+	}
+
+	if err := f.withClientTimeout(func() error { return f.client.HSet(f.key, fields, expiration) }); err != nil {
+		return err
+	}
+
+	f.setCached(true)
+	f.setDone(f.key, value)
+	return nil
+}
+
+// GetHash gets the hash set by SetHash into dst. dst must be a pointer to struct.
+// The field that is not in the hash is left as is.
+func (f *cacheFetcherImpl) GetHash(dst interface{}) error {
+	start := f.options.Clock.Now()
+	if err := f.getHash(dst); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) getHash(dst interface{}) error {
+	f.setCached(false)
+
+	if reflect.TypeOf(dst).Kind() != reflect.Ptr {
+		return fmt.Errorf("dst: %w", ErrNoPointerType)
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("dst: %w", ErrNoStructType)
+	}
+
+	var fields map[string]string
+	err := f.withClientTimeout(func() (err error) {
+		fields, err = f.client.HGetAll(f.key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported.
+		}
+
+		s, ok := fields[sf.Name]
+		if !ok {
+			continue
+		}
+
+		if err := decodeHashField(s, v.Field(i)); err != nil {
+			return fmt.Errorf("%s: %w", sf.Name, err)
+		}
+	}
+
+	f.setCached(true)
+	return nil
+}
+
+// GetHashField gets one field of the hash set by SetHash into dst without reading the whole hash.
+// field is the struct field name. A miss of the key or the field returns the client's cache miss error.
+func (f *cacheFetcherImpl) GetHashField(field string, dst interface{}) error {
+	start := f.options.Clock.Now()
+	if err := f.getHashField(field, dst); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) getHashField(field string, dst interface{}) error {
+	f.setCached(false)
+
+	if reflect.TypeOf(dst).Kind() != reflect.Ptr {
+		return fmt.Errorf("dst: %w", ErrNoPointerType)
+	}
+
+	var s string
+	err := f.withClientTimeout(func() (err error) {
+		s, err = f.client.HGet(f.key, field)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := decodeHashField(s, reflect.ValueOf(dst).Elem()); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+
+	f.setCached(true)
+	return nil
+}
+
+func encodeHashField(v reflect.Value) (string, error) {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Complex64:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 64), nil
+	case reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 128), nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v.Interface()); err != nil {
+		return "", fmt.Errorf("%w: %+v", ErrGobSerialized, err)
+	}
+	return buf.String(), nil
+}
+
+func decodeHashField(s string, v reflect.Value) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, v.Type().Bits())
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, v.Type().Bits())
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var fl float64
+		fl, err = strconv.ParseFloat(s, v.Type().Bits())
+		v.SetFloat(fl)
+	case reflect.Complex64, reflect.Complex128:
+		var c complex128
+		c, err = strconv.ParseComplex(s, v.Type().Bits())
+		v.SetComplex(c)
+	default:
+		if err := gob.NewDecoder(bytes.NewBufferString(s)).Decode(v.Addr().Interface()); err != nil {
+			return fmt.Errorf("%w: %+v", ErrGobSerialized, err)
+		}
+	}
+	return err
+}
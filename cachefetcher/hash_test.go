@@ -0,0 +1,144 @@
+package cachefetcher_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+type testHashStruct struct {
+	S  string
+	I  int
+	F  float64
+	B  bool
+	T  time.Time
+	SS []string
+	IP *int
+	u  int
+}
+
+func TestSetHashGetHash(t *testing.T) {
+	before()
+
+	i := 10
+	e := &testHashStruct{
+		S:  "abc",
+		I:  1,
+		F:  0.5,
+		B:  true,
+		T:  time.Unix(100, 0).In(time.UTC),
+		SS: []string{"a", "b"},
+		IP: &i,
+		u:  2,
+	}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hash"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetHash(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the field is readable as text.
+	if v, err := redisClient.Rdb.HGet(context.Background(), f.Key(), "I").Result(); err != nil || v != "1" {
+		t.Errorf("%#v, %#v", v, err)
+	}
+
+	var dst testHashStruct
+	if err := f.GetHash(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := *e
+	want.u = 0
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if !f.IsCached() {
+		t.Errorf("%#v", f.IsCached())
+	}
+}
+
+func TestGetHashField(t *testing.T) {
+	before()
+
+	e := &testHashStruct{S: "abc", I: 1, SS: []string{"a", "b"}}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hash"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var s string
+	if err := f.GetHashField("S", &s); !errors.Is(err, redis.Nil) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetHash(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.GetHashField("S", &s); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if s != e.S {
+		t.Errorf("%#v is not %#v", s, e.S)
+	}
+
+	var i int
+	if err := f.GetHashField("I", &i); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if i != e.I {
+		t.Errorf("%#v is not %#v", i, e.I)
+	}
+
+	var ss []string
+	if err := f.GetHashField("SS", &ss); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if !reflect.DeepEqual(ss, e.SS) {
+		t.Errorf("%#v is not %#v", ss, e.SS)
+	}
+
+	// the nil pointer field is not stored.
+	var ip int
+	if err := f.GetHashField("IP", &ip); !errors.Is(err, redis.Nil) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestGetHashError(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "hash"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testHashStruct
+	if err := f.GetHash(&dst); !errors.Is(err, redis.Nil) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.GetHash(dst); !errors.Is(err, cachefetcher.ErrNoPointerType) {
+		t.Errorf("%#v", err)
+	}
+
+	var s string
+	if err := f.GetHash(&s); !errors.Is(err, cachefetcher.ErrNoStructType) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetHash("a", 10*time.Second); !errors.Is(err, cachefetcher.ErrNoStructType) {
+		t.Errorf("%#v", err)
+	}
+}
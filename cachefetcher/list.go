@@ -0,0 +1,115 @@
+package cachefetcher
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Lister is optional for Client to store the list as the native list elements, e.g. Redis list.
+type Lister interface {
+	RPush(key string, values []interface{}, expiration time.Duration) error
+	LRange(key string) ([]string, error)
+}
+
+// ErrNotLister is the client does not implement Lister.
+var ErrNotLister = errors.New("cachefetcher: client is not lister")
+
+// AppendToList serializes one element and appends it to the list of the key, e.g. with RPUSH,
+// so that the cached list is updated incrementally without re-encoding the whole list.
+// expiration is of the whole list, and NoExpiration keeps the current expiration.
+// The client must implement Lister.
+func (f *cacheFetcherImpl) AppendToList(value interface{}, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.appendToList(value, expiration); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) appendToList(value interface{}, expiration time.Duration) error {
+	f.setCached(false)
+
+	c, ok := f.client.(Lister)
+	if !ok {
+		return ErrNotLister
+	}
+
+	expiration, err := f.resolveExpiration(expiration)
+	if err != nil {
+		return err
+	}
+
+	v, err := f.encode(value, false)
+	if err != nil {
+		return f.withKey(err)
+	}
+
+	if err := f.withClientTimeout(func() error { return c.RPush(f.key, []interface{}{v}, expiration) }); err != nil {
+		return err
+	}
+
+	f.setCached(true)
+	f.setDone(f.key, value)
+	return nil
+}
+
+// GetList reads the list of AppendToList, e.g. with LRANGE, and decodes each element into the slice dst.
+// dst must be a pointer to slice. The missing list is empty, and IsCached is false.
+// It does not use singleflight. The client must implement Lister.
+func (f *cacheFetcherImpl) GetList(dst interface{}) error {
+	start := f.options.Clock.Now()
+	if err := f.getList(dst); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) getList(dst interface{}) error {
+	f.setCached(false)
+
+	c, ok := f.client.(Lister)
+	if !ok {
+		return ErrNotLister
+	}
+
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dst: %w", ErrNoSliceType)
+	}
+
+	var values []string
+	err := f.withClientTimeout(func() (err error) {
+		values, err = c.LRange(f.key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	l := reflect.MakeSlice(dv.Elem().Type(), 0, len(values))
+	for _, s := range values {
+		e := reflect.New(l.Type().Elem())
+		if err := f.checkDst(e.Interface()); err != nil {
+			return err
+		}
+
+		if err := f.decode(s, e.Interface(), false); err != nil {
+			return f.withKey(err)
+		}
+		l = reflect.Append(l, e.Elem())
+	}
+
+	dv.Elem().Set(l)
+	f.setCached(len(values) > 0)
+	return nil
+}
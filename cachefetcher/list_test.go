@@ -0,0 +1,67 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestAppendToList(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "list"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst []testConcrete
+	if err := f.GetList(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if len(dst) != 0 || f.IsCached() {
+		t.Errorf("%#v, %#v", dst, f.IsCached())
+	}
+
+	want := []testConcrete{{A: 1, B: "a"}, {A: 2, B: "b"}, {A: 3, B: "c"}}
+	for _, e := range want {
+		if err := f.AppendToList(e, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	// each element is the native list element.
+	if n := len(redisClient.Rdb.LRange(ctx, f.Key(), 0, -1).Val()); n != len(want) {
+		t.Errorf("%#v is not %#v", n, len(want))
+	}
+
+	if err := f.GetList(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !reflect.DeepEqual(dst, want) || !f.IsCached() {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	var s string
+	if err := f.GetList(&s); !errors.Is(err, cachefetcher.ErrNoSliceType) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestAppendToListNotLister(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if err := f.AppendToList(1, 10*time.Second); !errors.Is(err, cachefetcher.ErrNotLister) {
+		t.Errorf("%#v", err)
+	}
+
+	var dst []int
+	if err := f.GetList(&dst); !errors.Is(err, cachefetcher.ErrNotLister) {
+		t.Errorf("%#v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package cachefetcher
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Meta is the metadata stored in the format header with Options.StoreMeta, e.g. for the cache auditing.
+type Meta struct {
+	WrittenAt time.Time // the zero time is not stored.
+	Source    string    // Options.SourceTag of the writer.
+}
+
+const (
+	// metaWrittenAt is the meta flag of the write time. It is followed by the unix nanos in 8 bytes.
+	metaWrittenAt byte = 1
+	// metaSource is the meta flag of the source. It is followed by the uvarint length of the source and the source.
+	metaSource byte = 2
+
+	writtenAtLen = 8
+)
+
+// GetMeta gets the metadata of the stored value without deserializing the value.
+// The value written without Options.StoreMeta returns the empty Meta, or only WrittenAt with Options.RefreshAhead.
+// A miss returns the cache miss error like Get.
+func (f *cacheFetcherImpl) GetMeta() (Meta, error) {
+	start := f.options.Clock.Now()
+	m, err := f.getMeta()
+	if err != nil {
+		return Meta{}, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return Meta{}, err
+	}
+	return m, nil
+}
+
+func (f *cacheFetcherImpl) getMeta() (Meta, error) {
+	f.setCached(false)
+
+	var s string
+	if err := f.withClientTimeout(func() error { return f.clientGet(&s) }); err != nil {
+		return Meta{}, err
+	}
+
+	f.setCached(true)
+	return metaOf(s), nil
+}
+
+// storesMeta reports whether the value is stamped with the metadata.
+func (f *cacheFetcherImpl) storesMeta() bool {
+	return f.options.StoreMeta || f.options.RefreshAhead > 0
+}
+
+// stampMeta adds the metadata to the format header of the encoded value s with Options.StoreMeta,
+// or the write time with Options.RefreshAhead.
+func (f *cacheFetcherImpl) stampMeta(s string) string {
+	var flags byte
+	if f.options.RefreshAhead > 0 {
+		flags |= metaWrittenAt
+	}
+	if f.options.StoreMeta {
+		flags |= metaWrittenAt | metaSource
+	}
+	if flags == 0 {
+		return s
+	}
+
+	src := f.options.SourceTag
+	b := make([]byte, formatHeaderLen+writtenAtLen, formatHeaderLen+writtenAtLen+binary.MaxVarintLen64+len(src)+len(s))
+	copy(b, s[:formatHeaderLen])
+	b[formatHeaderLen-1] = flags
+	binary.BigEndian.PutUint64(b[formatHeaderLen:], uint64(f.options.Clock.Now().UnixNano()))
+
+	if flags&metaSource != 0 {
+		var l [binary.MaxVarintLen64]byte
+		b = append(b, l[:binary.PutUvarint(l[:], uint64(len(src)))]...)
+		b = append(b, src...)
+	}
+	return string(append(b, s[formatHeaderLen:]...))
+}
+
+// metaOf returns the metadata of the stored value s. The value without it returns the empty Meta.
+func metaOf(s string) Meta {
+	if !hasFormatHeader(s) {
+		return Meta{}
+	}
+
+	_, _, m, _, err := splitHeader(s)
+	if err != nil {
+		return Meta{}
+	}
+	return m
+}
+
+// stripMeta returns the stored value s without the metadata, as it is encoded before stampMeta.
+func stripMeta(s string) string {
+	if !hasFormatHeader(s) || s[formatHeaderLen-1] == 0 {
+		return s
+	}
+
+	format, comp, _, b, err := splitHeader(s)
+	if err != nil {
+		return s
+	}
+	return formatMagic + string([]byte{format, comp, 0}) + b
+}
+
+func unixNanos(b string) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64([]byte(b))))
+}
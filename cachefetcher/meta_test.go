@@ -0,0 +1,161 @@
+package cachefetcher_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestGetMeta(t *testing.T) {
+	before()
+
+	clock := &manualClock{now: time.Unix(100, 0)}
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		StoreMeta: true,
+		SourceTag: "api@v1.2.3",
+		Clock:     clock,
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "meta"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the metadata is stored in the format header.
+	if v := redisClient.Rdb.Get(ctx, f.Key()).Val(); !strings.HasPrefix(v, "\x00cv") || strings.Count(v, "\x00c") != 1 {
+		t.Errorf("%#v", v)
+	}
+
+	want := cachefetcher.Meta{WrittenAt: time.Unix(100, 0), Source: "api@v1.2.3"}
+	if m, err := f.GetMeta(); err != nil || !m.WrittenAt.Equal(want.WrittenAt) || m.Source != want.Source {
+		t.Errorf("%#v, %#v", err, m)
+	}
+
+	// the value is readable with and without StoreMeta.
+	plain := factory.NewFetcher()
+	if err := plain.SetKey([]string{"prefix", "key"}, "meta"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	for _, ff := range []cachefetcher.CacheFetcher{f, plain} {
+		var dst testConcrete
+		if err := ff.Get(&dst); err != nil || dst.A != 1 {
+			t.Errorf("%#v, %#v", err, dst)
+		}
+	}
+
+	// the value without metadata degrades to the empty Meta.
+	if err := plain.Set(testConcrete{A: 2, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if m, err := f.GetMeta(); err != nil || m != (cachefetcher.Meta{}) || !f.IsCached() {
+		t.Errorf("%#v, %#v", err, m)
+	}
+
+	// the miss.
+	if err := f.Del(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if _, err := f.GetMeta(); !redisClient.IsErrCacheMiss(err) || f.IsCached() {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestGetMetaWithCompression(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		StoreMeta:    true,
+		FormatHeader: true,
+		Compression:  &cachefetcher.GzipCompressor{},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "meta"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if m, err := f.GetMeta(); err != nil || m.WrittenAt.IsZero() || m.Source != "" {
+		t.Errorf("%#v, %#v", err, m)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil || dst != "value" {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
+
+func TestDelIfEqualsWithMeta(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+	}{
+		{"store meta", &cachefetcher.Options{StoreMeta: true, SourceTag: "api"}},
+		{"refresh ahead", &cachefetcher.Options{RefreshAhead: time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &testConcrete{A: 1, B: "b"}
+
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(e, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if ok, err := f.DelIfEquals(&testConcrete{A: 2, B: "b"}); err != nil || ok {
+				t.Errorf("%#v, %#v", ok, err)
+			}
+
+			// the write time is not compared.
+			if ok, err := f.DelIfEquals(e); err != nil || !ok {
+				t.Errorf("%#v, %#v", ok, err)
+			}
+
+			if ok, err := f.Exists(); err != nil || ok {
+				t.Errorf("%#v, %#v", ok, err)
+			}
+
+			// the miss.
+			if ok, err := f.DelIfEquals(e); err != nil || ok {
+				t.Errorf("%#v, %#v", ok, err)
+			}
+		})
+	}
+}
+
+func TestMetaPrefixWithoutOption(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "meta"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the raw value like the legacy write time prefix is not stripped.
+	e := []byte("\x00ct12345678payload")
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst []byte
+	if err := f.Get(&dst); err != nil || string(dst) != string(e) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	if m, err := f.GetMeta(); err != nil || m != (cachefetcher.Meta{}) {
+		t.Errorf("%#v, %#v", err, m)
+	}
+}
@@ -0,0 +1,120 @@
+package cachefetcher
+
+import (
+	"reflect"
+	"time"
+)
+
+// MultiFetcherFunc fetches the missed keys of FetchMulti at once, e.g. with one upstream batch call.
+// It returns the values and the errors by key, so the partial success is representable.
+// The key in neither map is not found, and it is omitted from the result.
+type MultiFetcherFunc func(keys []string) (map[string]interface{}, map[string]error)
+
+// FetchMulti is GetMany that calls fetcher with the missed keys, and caches only the successfully fetched values.
+// The result maps the key to the dst of newDst, and errs maps the failed key to its error instead of failing the whole batch.
+// The decode error of a key and the set error are the error of the key.
+// It does not use singleflight.
+func (f *cacheFetcherImpl) FetchMulti(
+	keys []string, expiration time.Duration, newDst func(key string) interface{}, fetcher MultiFetcherFunc,
+) (map[string]interface{}, map[string]error) {
+	start := f.options.Clock.Now()
+	res, errs := f.fetchMulti(keys, expiration, newDst, fetcher)
+	_ = f.debugPrint(result{}, start)
+	return res, errs
+}
+
+func (f *cacheFetcherImpl) fetchMulti(
+	keys []string, expiration time.Duration, newDst func(key string) interface{}, fetcher MultiFetcherFunc,
+) (map[string]interface{}, map[string]error) {
+	res, errs := map[string]interface{}{}, map[string]error{}
+	if len(keys) == 0 {
+		return res, errs
+	}
+
+	var values map[string]string
+	err := f.withKeysClientTimeout(func() (err error) {
+		values, err = f.mget(keys)
+		return err
+	})
+	useCache := true
+	if err != nil {
+		if !f.isFallbackToFetcher(err) {
+			for _, k := range keys {
+				errs[k] = err
+			}
+			return res, errs
+		}
+		useCache = false
+	}
+
+	var missed []string
+	for _, k := range keys {
+		s, ok := values[k]
+		if !ok {
+			missed = append(missed, k)
+			continue
+		}
+
+		dst := newDst(k)
+		if err := f.checkDst(dst); err != nil {
+			errs[k] = err
+			continue
+		}
+
+		if err := f.decode(s, dst, false); err != nil {
+			if err = f.withGivenKey(k, err); f.isErrOnFetch(err) {
+				errs[k] = err
+				continue
+			}
+			missed = append(missed, k) // the decode error treated as cache miss.
+			continue
+		}
+		res[k] = dst
+	}
+
+	if len(missed) == 0 {
+		return res, errs
+	}
+
+	fetched, fErrs := fetcher(missed)
+	for k, err := range fErrs {
+		errs[k] = err
+	}
+
+	for _, k := range missed {
+		v, ok := fetched[k]
+		if !ok || fErrs[k] != nil {
+			continue
+		}
+
+		dst := newDst(k)
+		if err := setDst(dst, derefFetched(v, dst)); err != nil {
+			errs[k] = f.withGivenKey(k, err)
+			continue
+		}
+
+		if useCache {
+			c := &cacheFetcherImpl{client: f.client, options: f.options, key: k}
+			if err := c.set(v, expiration, false); err != nil && !c.isFallbackToFetcher(err) {
+				errs[k] = err
+				continue
+			}
+		}
+		res[k] = dst
+	}
+	return res, errs
+}
+
+// derefFetched dereferences the pointer value for the non-pointer dst, like the result of Fetch's fetcher.
+func derefFetched(v, dst interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	t := reflect.TypeOf(dst).Elem()
+	for rv.IsValid() && rv.Kind() == reflect.Ptr && !rv.IsNil() && !rv.Type().AssignableTo(t) {
+		rv = rv.Elem()
+	}
+
+	if !rv.IsValid() {
+		return v
+	}
+	return rv.Interface()
+}
@@ -0,0 +1,67 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFetchMulti(t *testing.T) {
+	before()
+
+	var keys []string
+	for _, e := range []string{"cached", "ok", "failed", "notfound"} {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "multi", e); err != nil {
+			t.Errorf("%#v", err)
+		}
+		keys = append(keys, f.Key())
+	}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "multi", "cached"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(testConcrete{A: 1, B: "cached"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	errUpstream := errors.New("upstream error")
+	var called []string
+	fetcher := func(missed []string) (map[string]interface{}, map[string]error) {
+		called = append(called, missed...)
+		return map[string]interface{}{keys[1]: &testConcrete{A: 2, B: "ok"}},
+			map[string]error{keys[2]: errUpstream}
+	}
+	newDst := func(key string) interface{} { return &testConcrete{} }
+
+	res, errs := f.FetchMulti(keys, 10*time.Second, newDst, fetcher)
+	want := map[string]interface{}{
+		keys[0]: &testConcrete{A: 1, B: "cached"},
+		keys[1]: &testConcrete{A: 2, B: "ok"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("%#v is not %#v", res, want)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[keys[2]], errUpstream) {
+		t.Errorf("%#v", errs)
+	}
+
+	if !reflect.DeepEqual(called, keys[1:]) {
+		t.Errorf("%#v is not %#v", called, keys[1:])
+	}
+
+	// only the successful entry is cached.
+	called = nil
+	res, errs = f.FetchMulti(keys, 10*time.Second, newDst, fetcher)
+	if !reflect.DeepEqual(res, want) || len(errs) != 1 {
+		t.Errorf("%#v, %#v", res, errs)
+	}
+
+	if !reflect.DeepEqual(called, keys[2:]) {
+		t.Errorf("%#v is not %#v", called, keys[2:])
+	}
+}
@@ -0,0 +1,333 @@
+package cachefetcher
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// Option sets the field of Options for NewFactoryWithOptions.
+// Any func(*Options) is Option, so the option without the builder can be set directly.
+type Option func(o *Options)
+
+// ErrInvalidOptions is the conflicting options.
+var ErrInvalidOptions = errors.New("cachefetcher: invalid options")
+
+// NewFactoryWithOptions is NewFactory built with the functional options.
+// It returns ErrInvalidOptions for the conflicting options, which NewFactory fixes and logs.
+// NewFactory with Options struct is kept.
+func NewFactoryWithOptions(client Client, opts ...Option) (Factory, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return NewFactory(client, options), nil
+}
+
+// WithSerializer sets Options.Serializer.
+func WithSerializer(s Serializer) Option {
+	return func(o *Options) { o.Serializer = s }
+}
+
+// WithCompression sets Options.Compression.
+func WithCompression(c Compressor) Option {
+	return func(o *Options) { o.Compression = c }
+}
+
+// WithFormatHeader sets Options.FormatHeader.
+func WithFormatHeader() Option {
+	return func(o *Options) { o.FormatHeader = true }
+}
+
+// WithNotSerialized sets Options.IsNotSerialized.
+func WithNotSerialized() Option {
+	return func(o *Options) { o.IsNotSerialized = true }
+}
+
+// WithKeyPrefix sets Options.KeyPrefix.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *Options) { o.KeyPrefix = prefix }
+}
+
+// WithSchemaVersion sets Options.SchemaVersion.
+func WithSchemaVersion(version string) Option {
+	return func(o *Options) { o.SchemaVersion = version }
+}
+
+// WithGroupTimeout sets Options.GroupTimeout.
+func WithGroupTimeout(d time.Duration) Option {
+	return func(o *Options) { o.GroupTimeout = d }
+}
+
+// WithClientTimeout sets Options.ClientTimeout.
+func WithClientTimeout(d time.Duration) Option {
+	return func(o *Options) { o.ClientTimeout = d }
+}
+
+// WithMaxValueBytes sets Options.MaxValueBytes.
+func WithMaxValueBytes(n int) Option {
+	return func(o *Options) { o.MaxValueBytes = n }
+}
+
+// WithDebugPrintHook sets Options.DebugPrintHook. It is the logger of the operations.
+func WithDebugPrintHook(hook DebugPrintHook) Option {
+	return func(o *Options) { o.DebugPrintHook = hook }
+}
+
+// WithTTLJitter sets Options.TTLJitter.
+func WithTTLJitter(d time.Duration) Option {
+	return func(o *Options) { o.TTLJitter = d }
+}
+
+// WithLogger sets Options.Logger, and Options.DebugPrintMode to print the lines to it.
+func WithLogger(l Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+		o.DebugPrintMode = true
+	}
+}
+
+// WithOnSet sets Options.OnSet.
+func WithOnSet(fn OnSetFunc) Option {
+	return func(o *Options) { o.OnSet = fn }
+}
+
+// optionRule is the invalid or conflicting options, and the fix of NewFactory.
+type optionRule struct {
+	desc    string
+	invalid func(o *Options) bool
+	fix     func(o *Options)
+}
+
+// optionRules are checked in order. The conflict with IsNotSerialized drops the other option.
+var optionRules = []optionRule{
+	{
+		"Serializer with IsNotSerialized",
+		func(o *Options) bool {
+			return o.IsNotSerialized && o.Serializer != nil && !isGobSerializer(o.Serializer)
+		},
+		func(o *Options) { o.Serializer = nil },
+	},
+	{
+		"TypeSerializers with IsNotSerialized",
+		func(o *Options) bool { return o.IsNotSerialized && len(o.TypeSerializers) > 0 },
+		func(o *Options) { o.TypeSerializers = nil },
+	},
+	{
+		"DecodeFallbacks with IsNotSerialized",
+		func(o *Options) bool { return o.IsNotSerialized && len(o.DecodeFallbacks) > 0 },
+		func(o *Options) { o.DecodeFallbacks = nil },
+	},
+	{
+		"Compression with IsNotSerialized",
+		func(o *Options) bool { return o.IsNotSerialized && o.Compression != nil },
+		func(o *Options) { o.Compression = nil },
+	},
+	{
+		"FormatHeader with IsNotSerialized",
+		func(o *Options) bool { return o.IsNotSerialized && o.FormatHeader },
+		func(o *Options) { o.FormatHeader = false },
+	},
+	{
+		"StoreMeta or RefreshAhead with IsNotSerialized",
+		func(o *Options) bool { return o.IsNotSerialized && (o.StoreMeta || o.RefreshAhead > 0) },
+		func(o *Options) { o.StoreMeta, o.SourceTag, o.RefreshAhead = false, "", 0 },
+	},
+	{
+		"nil TypeSerializers",
+		func(o *Options) bool {
+			for _, s := range o.TypeSerializers {
+				if s == nil {
+					return true
+				}
+			}
+			return false
+		},
+		func(o *Options) {
+			ts := make(map[reflect.Type]Serializer, len(o.TypeSerializers))
+			for t, s := range o.TypeSerializers {
+				if s != nil {
+					ts[t] = s
+				}
+			}
+			o.TypeSerializers = ts
+		},
+	},
+	{
+		"nil DecodeFallbacks",
+		func(o *Options) bool {
+			for _, s := range o.DecodeFallbacks {
+				if s == nil {
+					return true
+				}
+			}
+			return false
+		},
+		func(o *Options) {
+			var fbs []Serializer
+			for _, s := range o.DecodeFallbacks {
+				if s != nil {
+					fbs = append(fbs, s)
+				}
+			}
+			o.DecodeFallbacks = fbs
+		},
+	},
+	{
+		"conflicting serializer ID",
+		func(o *Options) bool {
+			ok := serializerIDCheck()
+			if !ok(o.Serializer) {
+				return true
+			}
+			for _, s := range o.TypeSerializers {
+				if !ok(s) {
+					return true
+				}
+			}
+			for _, s := range o.DecodeFallbacks {
+				if !ok(s) {
+					return true
+				}
+			}
+			return false
+		},
+		func(o *Options) {
+			ok := serializerIDCheck()
+			if !ok(o.Serializer) {
+				o.Serializer = nil
+			}
+			ts := make(map[reflect.Type]Serializer, len(o.TypeSerializers))
+			for t, s := range o.TypeSerializers {
+				if ok(s) {
+					ts[t] = s
+				}
+			}
+			o.TypeSerializers = ts
+			var fbs []Serializer
+			for _, s := range o.DecodeFallbacks {
+				if ok(s) {
+					fbs = append(fbs, s)
+				}
+			}
+			o.DecodeFallbacks = fbs
+		},
+	},
+	{
+		"negative DefaultExpiration",
+		func(o *Options) bool { return o.DefaultExpiration < 0 },
+		func(o *Options) { o.DefaultExpiration = 0 },
+	},
+	{
+		"negative TTLJitter",
+		func(o *Options) bool { return o.TTLJitter < 0 },
+		func(o *Options) { o.TTLJitter = 0 },
+	},
+	{
+		"negative timeout",
+		func(o *Options) bool { return o.GroupTimeout < 0 || o.ClientTimeout < 0 },
+		func(o *Options) {
+			if o.GroupTimeout < 0 {
+				o.GroupTimeout = 0
+			}
+			if o.ClientTimeout < 0 {
+				o.ClientTimeout = 0
+			}
+		},
+	},
+	{
+		"negative MaxValueBytes",
+		func(o *Options) bool { return o.MaxValueBytes < 0 },
+		func(o *Options) { o.MaxValueBytes = 0 },
+	},
+	{
+		"SkipOversized without MaxValueBytes",
+		func(o *Options) bool { return o.SkipOversized && o.MaxValueBytes == 0 },
+		func(o *Options) { o.SkipOversized = false },
+	},
+	{
+		"negative AsyncWriteWorkers or AsyncWriteQueueSize",
+		func(o *Options) bool { return o.AsyncWriteWorkers < 0 || o.AsyncWriteQueueSize < 0 },
+		func(o *Options) {
+			if o.AsyncWriteWorkers < 0 {
+				o.AsyncWriteWorkers = 0
+			}
+			if o.AsyncWriteQueueSize < 0 {
+				o.AsyncWriteQueueSize = 0
+			}
+		},
+	},
+	{
+		"negative BreakerThreshold or BreakerCooldown",
+		func(o *Options) bool { return o.BreakerThreshold < 0 || o.BreakerCooldown < 0 },
+		func(o *Options) {
+			if o.BreakerThreshold < 0 {
+				o.BreakerThreshold = 0
+			}
+			if o.BreakerCooldown < 0 {
+				o.BreakerCooldown = 0
+			}
+		},
+	},
+	{
+		"SourceTag without StoreMeta",
+		func(o *Options) bool { return o.SourceTag != "" && !o.StoreMeta },
+		func(o *Options) { o.SourceTag = "" },
+	},
+	{
+		"negative RefreshAhead",
+		func(o *Options) bool { return o.RefreshAhead < 0 },
+		func(o *Options) { o.RefreshAhead = 0 },
+	},
+	{
+		"negative HashKeyReadablePrefixLen",
+		func(o *Options) bool { return o.HashKeyReadablePrefixLen < 0 },
+		func(o *Options) { o.HashKeyReadablePrefixLen = 0 },
+	},
+}
+
+// Validate returns ErrInvalidOptions with the description for the conflicting or invalid options,
+// so that the misconfiguration is the startup failure instead of being ignored silently.
+// NewFactoryWithOptions returns the error, and NewFactory fixes the options instead.
+func (o *Options) Validate() error {
+	for _, r := range optionRules {
+		if r.invalid(o) {
+			return fmt.Errorf("%w: %s", ErrInvalidOptions, r.desc)
+		}
+	}
+	return nil
+}
+
+// normalize fixes the options that Validate rejects, e.g. the negative duration to 0,
+// and logs each fix to Options.Logger, or to the standard logger.
+func (o *Options) normalize() {
+	for _, r := range optionRules {
+		if !r.invalid(o) {
+			continue
+		}
+
+		r.fix(o)
+		o.logf("cachefetcher: ignored the invalid options: %s", r.desc)
+	}
+}
+
+// logf logs to Options.Logger, or to the standard logger.
+func (o *Options) logf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// isGobSerializer reports whether s is the default serializer of NewFactory, so that the defaulted Options is valid.
+func isGobSerializer(s Serializer) bool {
+	_, ok := s.(*GobSerializer)
+	return ok
+}
@@ -0,0 +1,206 @@
+package cachefetcher_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestNewFactoryWithOptions(t *testing.T) {
+	before()
+
+	var keys []string
+	factory, err := cachefetcher.NewFactoryWithOptions(
+		redisClient,
+		cachefetcher.WithSerializer(&cachefetcher.JSONSerializer{}),
+		cachefetcher.WithCompression(&cachefetcher.GzipCompressor{}),
+		cachefetcher.WithFormatHeader(),
+		cachefetcher.WithKeyPrefix("svc"),
+		cachefetcher.WithOnSet(func(key string, _ interface{}) { keys = append(keys, key) }),
+	)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "option"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if f.Key() != "svc_prefix_key_option" {
+		t.Errorf("%#v", f.Key())
+	}
+
+	want := testConcrete{A: 1, B: "b"}
+	if err := f.Set(want, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testConcrete
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != want {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	if len(keys) != 1 || keys[0] != f.Key() {
+		t.Errorf("%#v", keys)
+	}
+}
+
+func TestNewFactoryWithOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []cachefetcher.Option
+	}{
+		{"serializer", []cachefetcher.Option{cachefetcher.WithNotSerialized(), cachefetcher.WithSerializer(&cachefetcher.JSONSerializer{})}},
+		{"compression", []cachefetcher.Option{cachefetcher.WithNotSerialized(), cachefetcher.WithCompression(&cachefetcher.GzipCompressor{})}},
+		{"header", []cachefetcher.Option{cachefetcher.WithNotSerialized(), cachefetcher.WithFormatHeader()}},
+		{"timeout", []cachefetcher.Option{cachefetcher.WithClientTimeout(-time.Second)}},
+		{"max value bytes", []cachefetcher.Option{cachefetcher.WithMaxValueBytes(-1)}},
+		{"ttl jitter", []cachefetcher.Option{cachefetcher.WithTTLJitter(-time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := cachefetcher.NewFactoryWithOptions(redisClient, tt.opts...); !errors.Is(err, cachefetcher.ErrInvalidOptions) {
+				t.Errorf("%#v", err)
+			}
+		})
+	}
+}
+
+func TestWithTTLJitter(t *testing.T) {
+	before()
+
+	client := &expirationClient{SimpleRedisClientImpl: redisClient}
+	factory, err := cachefetcher.NewFactoryWithOptions(client, cachefetcher.WithTTLJitter(time.Second))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "ttljitter"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the expiration is spread in [10s, 11s).
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 10; i++ {
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if client.expiration < 10*time.Second || client.expiration >= 11*time.Second {
+			t.Errorf("%#v", client.expiration)
+		}
+		seen[client.expiration] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("%#v", seen)
+	}
+
+	// NoExpiration is not jittered.
+	if err := f.Set("value", cachefetcher.NoExpiration); err != nil || client.expiration != 0 {
+		t.Errorf("%#v, %#v", err, client.expiration)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	before()
+
+	var buf bytes.Buffer
+	factory, err := cachefetcher.NewFactoryWithOptions(redisClient, cachefetcher.WithLogger(log.New(&buf, "", 0)))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "logger"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := "cachefetcher.(*cacheFetcherImpl).Set: key:prefix_key_logger, cache:true, elapsed:"
+	if lines := strings.Split(buf.String(), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], want) {
+		t.Errorf("%#v", buf.String())
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	ts := map[reflect.Type]cachefetcher.Serializer{reflect.TypeOf(0): &cachefetcher.JSONSerializer{}}
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+	}{
+		{"serializer", &cachefetcher.Options{IsNotSerialized: true, Serializer: &cachefetcher.JSONSerializer{}}},
+		{"compression", &cachefetcher.Options{IsNotSerialized: true, Compression: &cachefetcher.GzipCompressor{}}},
+		{"header", &cachefetcher.Options{IsNotSerialized: true, FormatHeader: true}},
+		{"meta", &cachefetcher.Options{IsNotSerialized: true, StoreMeta: true}},
+		{"type serializers", &cachefetcher.Options{IsNotSerialized: true, TypeSerializers: ts}},
+		{"nil type serializer", &cachefetcher.Options{TypeSerializers: map[reflect.Type]cachefetcher.Serializer{reflect.TypeOf(0): nil}}},
+		{"decode fallbacks", &cachefetcher.Options{IsNotSerialized: true, DecodeFallbacks: []cachefetcher.Serializer{&cachefetcher.GobSerializer{}}}},
+		{"nil decode fallback", &cachefetcher.Options{DecodeFallbacks: []cachefetcher.Serializer{nil}}},
+		{"raw serializer id", &cachefetcher.Options{Serializer: &idSerializer{id: cachefetcher.SerializerIDRaw}}},
+		{"serializer id", &cachefetcher.Options{Serializer: &idSerializer{id: cachefetcher.SerializerIDGob}}},
+		{"type serializer id", &cachefetcher.Options{
+			TypeSerializers: map[reflect.Type]cachefetcher.Serializer{reflect.TypeOf(0): &idSerializer{id: cachefetcher.SerializerIDJSON}},
+		}},
+		{"decode fallback id", &cachefetcher.Options{DecodeFallbacks: []cachefetcher.Serializer{&idSerializer{id: cachefetcher.SerializerIDBinary}}}},
+		{"same serializer id", &cachefetcher.Options{
+			Serializer:      concreteSerializer{},
+			DecodeFallbacks: []cachefetcher.Serializer{&idSerializer{id: 101}},
+		}},
+		{"default expiration", &cachefetcher.Options{DefaultExpiration: -time.Second}},
+		{"ttl jitter", &cachefetcher.Options{TTLJitter: -time.Second}},
+		{"group timeout", &cachefetcher.Options{GroupTimeout: -time.Second}},
+		{"client timeout", &cachefetcher.Options{ClientTimeout: -time.Second}},
+		{"max value bytes", &cachefetcher.Options{MaxValueBytes: -1}},
+		{"skip oversized", &cachefetcher.Options{SkipOversized: true}},
+		{"async write workers", &cachefetcher.Options{AsyncWriteWorkers: -1}},
+		{"async write queue size", &cachefetcher.Options{AsyncWriteQueueSize: -1}},
+		{"breaker threshold", &cachefetcher.Options{BreakerThreshold: -1}},
+		{"breaker cooldown", &cachefetcher.Options{BreakerCooldown: -time.Second}},
+		{"source tag", &cachefetcher.Options{SourceTag: "v1"}},
+		{"refresh ahead", &cachefetcher.Options{RefreshAhead: -time.Second}},
+		{"hash key readable prefix len", &cachefetcher.Options{HashKeyReadablePrefixLen: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); !errors.Is(err, cachefetcher.ErrInvalidOptions) {
+				t.Errorf("%#v", err)
+			}
+
+			// NewFactory fixes and logs them.
+			var buf bytes.Buffer
+			tt.options.Logger = log.New(&buf, "", 0)
+			cachefetcher.NewFactory(redisClient, tt.options)
+			if err := tt.options.Validate(); err != nil || !strings.Contains(buf.String(), "invalid options") {
+				t.Errorf("%#v, %#v", err, buf.String())
+			}
+		})
+	}
+
+	// the options defaulted by NewFactory are valid.
+	o := &cachefetcher.Options{IsNotSerialized: true, SkipOversized: true, MaxValueBytes: 10}
+	cachefetcher.NewFactory(redisClient, o)
+	if err := o.Validate(); err != nil {
+		t.Errorf("%#v", err)
+	}
+}
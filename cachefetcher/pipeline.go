@@ -0,0 +1,159 @@
+package cachefetcher
+
+import "time"
+
+type (
+	// Pipeline queues the commands of the composed keys, e.g. Key()'s result.
+	// The commands are flushed once after the Pipeline function returns.
+	Pipeline interface {
+		Set(key string, value interface{}, expiration time.Duration)
+		Get(key string, dst interface{})
+		Del(key string)
+	}
+
+	// Pipeliner is optional for Client to run the queued commands in one round trip.
+	// It sets each op's Result and Err.
+	Pipeliner interface {
+		Pipelined(ops []*PipelineOp) error
+	}
+
+	// PipelineOp is a queued command.
+	PipelineOp struct {
+		Cmd        string // PipelineCmdSet, PipelineCmdGet or PipelineCmdDel.
+		Key        string
+		Value      interface{} // Set's stored value.
+		Expiration time.Duration
+		Result     string // Get's stored value.
+		Err        error
+	}
+
+	pipelineImpl struct {
+		f      *cacheFetcherImpl
+		ops    []*PipelineOp
+		dsts   map[*PipelineOp]interface{}
+		values map[*PipelineOp]interface{} // Set's value before encoding for Options.OnSet.
+		err    error
+	}
+)
+
+const (
+	// PipelineCmdSet is PipelineOp's Set command.
+	PipelineCmdSet = "set"
+
+	// PipelineCmdGet is PipelineOp's Get command.
+	PipelineCmdGet = "get"
+
+	// PipelineCmdDel is PipelineOp's Del command.
+	PipelineCmdDel = "del"
+)
+
+// Pipeline queues the commands in fn, and flushes them once.
+// If the client does not implement Pipeliner, the commands are called sequentially.
+// It returns the first error other than cache miss. The dst of the missed Get is left as is.
+func (f *cacheFetcherImpl) Pipeline(fn func(p Pipeline)) error {
+	start := f.options.Clock.Now()
+	if err := f.pipeline(fn); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) pipeline(fn func(p Pipeline)) error {
+	p := &pipelineImpl{f: f, dsts: map[*PipelineOp]interface{}{}, values: map[*PipelineOp]interface{}{}}
+	fn(p)
+	if p.err != nil {
+		return p.err
+	}
+	if len(p.ops) == 0 {
+		return nil
+	}
+
+	var err error
+	if c, ok := f.client.(Pipeliner); ok {
+		err = f.withKeysClientTimeout(func() error { return c.Pipelined(p.ops) })
+	} else {
+		err = f.withKeysClientTimeout(func() error { f.sequential(p.ops); return nil })
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, op := range p.ops {
+		if f.isErrOtherThanCacheMiss(op.Err) {
+			return f.withGivenKey(op.Key, op.Err)
+		}
+
+		if value, ok := p.values[op]; ok && op.Err == nil {
+			f.setDone(op.Key, value)
+		}
+		if dst, ok := p.dsts[op]; ok && op.Err == nil {
+			if err := f.decode(op.Result, dst, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sequential calls ops one by one without Pipeliner.
+func (f *cacheFetcherImpl) sequential(ops []*PipelineOp) {
+	for _, op := range ops {
+		switch op.Cmd {
+		case PipelineCmdSet:
+			op.Err = f.client.Set(op.Key, op.Value, op.Expiration)
+		case PipelineCmdGet:
+			op.Err = f.client.Get(op.Key, &op.Result)
+		case PipelineCmdDel:
+			op.Err = f.client.Del(op.Key)
+		}
+	}
+}
+
+func (p *pipelineImpl) Set(key string, value interface{}, expiration time.Duration) {
+	if p.err != nil {
+		return
+	}
+	expiration, err := p.f.resolveExpiration(expiration)
+	if err != nil {
+		p.err = err
+		return
+	}
+
+	v, skip, err := p.f.storedValue(key, value, false)
+	if err != nil {
+		p.err = err
+		return
+	}
+	if skip {
+		return // the skipped value is not cached.
+	}
+
+	op := &PipelineOp{Cmd: PipelineCmdSet, Key: key, Value: v, Expiration: expiration}
+	p.ops = append(p.ops, op)
+	p.values[op] = value
+}
+
+func (p *pipelineImpl) Get(key string, dst interface{}) {
+	if p.err != nil {
+		return
+	}
+	if err := p.f.checkDst(dst); err != nil {
+		p.err = err
+		return
+	}
+
+	op := &PipelineOp{Cmd: PipelineCmdGet, Key: key}
+	p.ops = append(p.ops, op)
+	p.dsts[op] = dst
+}
+
+func (p *pipelineImpl) Del(key string) {
+	if p.err != nil {
+		return
+	}
+	p.ops = append(p.ops, &PipelineOp{Cmd: PipelineCmdDel, Key: key})
+}
@@ -0,0 +1,106 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name   string
+		client cachefetcher.Client
+	}{
+		{"pipeliner", redisClient},
+		{"sequential", struct{ cachefetcher.Client }{redisClient}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before()
+
+			f := cachefetcher.NewFactory(tt.client, nil).NewFetcher()
+			if err := f.Pipeline(func(p cachefetcher.Pipeline) {
+				p.Set("prefix_key_a", &testConcrete{A: 1, B: "a"}, 10*time.Second)
+				p.Set("prefix_key_b", "b", 10*time.Second)
+				p.Set("prefix_key_c", "c", 10*time.Second)
+			}); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var a testConcrete
+			var b, missing string
+			if err := f.Pipeline(func(p cachefetcher.Pipeline) {
+				p.Get("prefix_key_a", &a)
+				p.Get("prefix_key_b", &b)
+				p.Get("prefix_key_missing", &missing)
+				p.Del("prefix_key_c")
+			}); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if want := (testConcrete{A: 1, B: "a"}); !reflect.DeepEqual(a, want) {
+				t.Errorf("%#v is not %#v", a, want)
+			}
+
+			if b != "b" || missing != "" {
+				t.Errorf("%#v, %#v", b, missing)
+			}
+
+			keys, err := f.Scan("prefix_key")
+			if err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if want := []string{"prefix_key_a", "prefix_key_b"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("%#v is not %#v", keys, want)
+			}
+		})
+	}
+}
+
+func TestPipelineSetOptions(t *testing.T) {
+	before()
+
+	// the value is written as Set writes it.
+	var setKeys []string
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		StoreMeta:     true,
+		MaxValueBytes: 64,
+		OnSet:         func(key string, value interface{}) { setKeys = append(setKeys, key) },
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "a"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Pipeline(func(p cachefetcher.Pipeline) {
+		p.Set(f.Key(), "a", 10*time.Second)
+	}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if m, err := f.GetMeta(); err != nil || m.WrittenAt.IsZero() {
+		t.Errorf("%#v, %#v", m, err)
+	}
+
+	if want := []string{f.Key()}; !reflect.DeepEqual(setKeys, want) || f.Stats().Sets != 1 {
+		t.Errorf("%#v, %#v", setKeys, f.Stats())
+	}
+
+	// the oversized value fails the pipeline, and the later Del is not queued.
+	err := f.Pipeline(func(p cachefetcher.Pipeline) {
+		p.Set("prefix_key_large", strings.Repeat("a", 100), 10*time.Second)
+		p.Del(f.Key())
+	})
+	if !errors.Is(err, cachefetcher.ErrValueTooLarge) || !strings.Contains(err.Error(), "prefix_key_large") {
+		t.Errorf("%#v", err)
+	}
+
+	if ok, err := f.Exists(); err != nil || !ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+}
@@ -0,0 +1,46 @@
+package cachefetcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Pool reuses the fetchers of the same client and options with sync.Pool,
+// e.g. to avoid the allocation per request in the hot HTTP path.
+type Pool struct {
+	client  Client
+	options *Options
+	pool    sync.Pool
+}
+
+// NewPool returns Pool of client and options. The options are defaulted like NewFactory.
+func NewPool(client Client, options *Options) *Pool {
+	b := NewFactory(client, options).(*factoryImpl)
+	p := &Pool{client: b.client, options: b.options}
+	p.pool.New = func() interface{} {
+		return &cacheFetcherImpl{client: p.client, options: p.options}
+	}
+	return p
+}
+
+// Get returns the fetcher with the empty key, like Factory's NewFetcher.
+func (p *Pool) Get() CacheFetcher {
+	return p.pool.Get().(*cacheFetcherImpl)
+}
+
+// Put resets the key and the state of f, and returns it to the pool.
+// f must not be used after Put, because it may be returned by another Get.
+// The fetcher not from this pool is ignored. The fetcher whose background work is in flight,
+// e.g. the fetcher function after GroupTimeout or the client call after ClientTimeout, is dropped, because the work still uses it.
+func (p *Pool) Put(f CacheFetcher) {
+	c, ok := f.(*cacheFetcherImpl)
+	if !ok || c.client != p.client || c.options != p.options || !c.idle() {
+		return
+	}
+
+	c.mu.Lock()
+	c.key, c.groupKeyOverride, c.parts = "", "", keyParts{}
+	c.isCached, c.tier, c.writtenAt = false, "", time.Time{}
+	c.mu.Unlock()
+	p.pool.Put(c)
+}
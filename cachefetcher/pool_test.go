@@ -0,0 +1,100 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestPool(t *testing.T) {
+	before()
+
+	p := cachefetcher.NewPool(redisClient, nil)
+	f := p.Get()
+	if err := f.SetKey([]string{"prefix", "key"}, "pool"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil || !f.IsCached() {
+		t.Errorf("%#v, %#v", err, f.IsCached())
+	}
+	p.Put(f)
+
+	// the reused fetcher has the empty key.
+	f = p.Get()
+	if f.Key() != "" || f.IsCached() {
+		t.Errorf("%#v, %#v", f.Key(), f.IsCached())
+	}
+
+	if err := f.SetKey([]string{"prefix", "key"}, "pool"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil || dst != "value" {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+	p.Put(f)
+
+	// the fetcher not from the pool is ignored.
+	p.Put(factory.NewFetcher())
+}
+
+func TestPoolInFlight(t *testing.T) {
+	before()
+
+	// GroupTimeout fires without waiting, and the fetcher function keeps running.
+	p := cachefetcher.NewPool(redisClient, &cachefetcher.Options{Clock: &fakeClock{now: time.Unix(0, 0)}})
+	f := p.Get()
+	if err := f.SetKey([]string{"prefix", "key"}, "poolinflight"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	release, done := make(chan struct{}), make(chan struct{})
+	var dst string
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) {
+		defer close(done)
+		<-release
+		return "value", nil
+	}); !errors.Is(err, cachefetcher.ErrTimeout) {
+		t.Errorf("%#v", err)
+	}
+
+	// the in-flight fetcher is dropped, so it is not reused while the work writes it.
+	p.Put(f)
+	if g := p.Get(); g == f {
+		t.Errorf("%#v", g)
+	}
+
+	close(release)
+	<-done
+}
+
+func BenchmarkNewFetcher(b *testing.B) {
+	factory := cachefetcher.NewFactory(redisClient, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "bench"); err != nil {
+			b.Fatalf("%#v", err)
+		}
+	}
+}
+
+func BenchmarkPool(b *testing.B) {
+	p := cachefetcher.NewPool(redisClient, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := p.Get()
+		if err := f.SetKey([]string{"prefix", "key"}, "bench"); err != nil {
+			b.Fatalf("%#v", err)
+		}
+		p.Put(f)
+	}
+}
@@ -0,0 +1,41 @@
+package cachefetcher
+
+import (
+	"errors"
+	"time"
+)
+
+// Expirer is optional for Client to update the expiration of the key.
+type Expirer interface {
+	Expire(key string, expiration time.Duration) error
+}
+
+const opRefresh = "refresh"
+
+// ErrNotExpirer is the client does not implement Expirer.
+var ErrNotExpirer = errors.New("cachefetcher: client is not expirer")
+
+// refreshTTL bumps the expiration of the hit key in the background with Options.RefreshTTLOnHit,
+// so that the frequently-read keys stay warm without adding latency to the read.
+// The error is notified to the hook with "refresh" op. NoExpiration is not refreshed.
+func (f *cacheFetcherImpl) refreshTTL(expiration time.Duration) {
+	if !f.options.RefreshTTLOnHit {
+		return
+	}
+	if expiration, _ = f.resolveExpiration(expiration); expiration <= 0 {
+		return
+	}
+
+	key := f.key // the fetcher may be reused for another key before the goroutine runs.
+	c, ok := f.client.(Expirer)
+	if !ok {
+		f.notify(Event{Op: opRefresh, Key: key, Err: ErrNotExpirer})
+		return
+	}
+
+	go func() {
+		if err := c.Expire(key, expiration); err != nil && !f.isErrCacheMiss(err) {
+			f.notify(Event{Op: opRefresh, Key: key, Err: err})
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package cachefetcher
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	opRefreshAhead     = "refreshahead"
+	refreshAheadSuffix = "refreshahead"
+)
+
+// FetchRefreshAhead is Fetch that refreshes the hit value older than Options.RefreshAhead in the background,
+// so that the hot keys are replaced before they expire and the callers never wait for the fetcher.
+// The hit value is returned immediately, and the refresh is deduplicated by key.
+// The refresh error is notified to the hook with "refreshahead" op, and the cached value is kept.
+// The value without the write time, e.g. written without Options.RefreshAhead, is not refreshed.
+func (f *cacheFetcherImpl) FetchRefreshAhead(expiration time.Duration, dst interface{}, fetcher interface{}) error {
+	f.setWrittenAt(time.Time{}) // the caller waiting for the shared get does not read the value.
+	if err := f.Fetch(expiration, dst, fetcher); err != nil {
+		return err
+	}
+
+	if f.cached() && f.isRefreshAheadDue() {
+		f.refreshAhead(expiration, fetcher)
+	}
+	return nil
+}
+
+// isRefreshAheadDue reports whether the last got value is older than Options.RefreshAhead.
+func (f *cacheFetcherImpl) isRefreshAheadDue() bool {
+	writtenAt := f.lastWrittenAt()
+	if f.options.RefreshAhead <= 0 || writtenAt.IsZero() {
+		return false
+	}
+	return f.options.Clock.Now().Sub(writtenAt) >= f.options.RefreshAhead
+}
+
+// refreshAhead calls fetcher and sets the result in the background.
+func (f *cacheFetcherImpl) refreshAhead(expiration time.Duration, fetcher interface{}) {
+	key := f.key // the fetcher may be reused for another key before the refresh runs.
+	c := &cacheFetcherImpl{client: f.client, options: f.options, key: key}
+	f.doChanWithKey(context.Background(), f.flightKey()+sep+refreshAheadSuffix, func() (interface{}, error) {
+		v, err := c.callFetcher(context.Background(), expiration, fetcher)
+		if err != nil {
+			c.notify(Event{Op: opRefreshAhead, Key: key, Err: err})
+		}
+		return v, err
+	})
+}
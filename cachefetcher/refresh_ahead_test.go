@@ -0,0 +1,107 @@
+package cachefetcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestFetchRefreshAhead(t *testing.T) {
+	before()
+
+	clock := &manualClock{now: time.Unix(0, 0)}
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{RefreshAhead: time.Minute, Clock: clock}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "refreshahead"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	calls := 0
+	refreshed := make(chan struct{}, 1)
+	fetcher := func() (testConcrete, error) {
+		calls++
+		if calls > 1 {
+			defer func() { refreshed <- struct{}{} }()
+		}
+		return testConcrete{A: calls, B: "b"}, nil
+	}
+
+	// the miss and the fresh hit.
+	for i, want := range []int{1, 1} {
+		var dst testConcrete
+		if err := f.FetchRefreshAhead(10*time.Minute, &dst, fetcher); err != nil || dst.A != want {
+			t.Errorf("%d: %#v, %#v", i, err, dst)
+		}
+	}
+
+	// the old hit returns the cached value, and refreshes it in the background.
+	clock.advance(time.Minute)
+	var dst testConcrete
+	if err := f.FetchRefreshAhead(10*time.Minute, &dst, fetcher); err != nil || dst.A != 1 || !f.IsCached() {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("not refreshed")
+	}
+
+	// wait for the set after the fetcher.
+	for i := 0; i < 100; i++ {
+		if err := f.Get(&dst); err == nil && dst.A == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dst.A != 2 {
+		t.Errorf("%#v", dst)
+	}
+
+	// the refreshed value is fresh.
+	if err := f.FetchRefreshAhead(10*time.Minute, &dst, fetcher); err != nil || dst.A != 2 || calls != 2 {
+		t.Errorf("%#v, %#v, %#v", err, dst, calls)
+	}
+}
+
+func TestFetchRefreshAheadWithoutWrittenAt(t *testing.T) {
+	before()
+
+	clock := &manualClock{now: time.Unix(0, 0)}
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{RefreshAhead: time.Minute, Clock: clock}).NewFetcher()
+	plain := factory.NewFetcher()
+	for _, ff := range []cachefetcher.CacheFetcher{f, plain} {
+		if err := ff.SetKey([]string{"prefix", "key"}, "refreshahead"); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	// the value written without RefreshAhead is not refreshed.
+	if err := plain.Set(testConcrete{A: 1, B: "b"}, 10*time.Minute); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	clock.advance(time.Hour)
+	called := make(chan struct{}, 1)
+	var dst testConcrete
+	if err := f.FetchRefreshAhead(10*time.Minute, &dst, func() (testConcrete, error) {
+		called <- struct{}{}
+		return testConcrete{A: 2, B: "b"}, nil
+	}); err != nil || dst.A != 1 {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	select {
+	case <-called:
+		t.Error("refreshed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the stamped value is readable without RefreshAhead.
+	if err := f.Set(testConcrete{A: 3, B: "b"}, 10*time.Minute); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := plain.Get(&dst); err != nil || dst.A != 3 {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
@@ -0,0 +1,75 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestRefreshTTLOnHit(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{RefreshTTLOnHit: true}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "refresh"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Fetch(100*time.Second, &dst, func() (string, error) { return "", errors.New("not called") }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the refresh is in the background.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if ttl := redisClient.Rdb.TTL(ctx, f.Key()).Val(); ttl > 10*time.Second {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%#v is not refreshed", redisClient.Rdb.TTL(ctx, f.Key()).Val())
+			break
+		}
+	}
+}
+
+func TestRefreshTTLOnHitNotExpirer(t *testing.T) {
+	before()
+
+	var mu sync.Mutex
+	var errs []error
+	f := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, &cachefetcher.Options{
+		RefreshTTLOnHit: true,
+		DebugPrintHook: func(e cachefetcher.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Err != nil {
+				errs = append(errs, e.Err)
+			}
+		},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "refresh"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the read is not failed.
+	var dst string
+	if err := f.Fetch(100*time.Second, &dst, func() (string, error) { return "", errors.New("not called") }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || !errors.Is(errs[0], cachefetcher.ErrNotExpirer) {
+		t.Errorf("%#v", errs)
+	}
+}
@@ -0,0 +1,417 @@
+package cachefetcher
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Serializer serializes the stored value except string and []byte.
+// ID is stored in the format header, so that get picks the right deserializer.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, dst interface{}) error
+	ID() byte
+}
+
+type (
+	// GobSerializer is gob Serializer. It is the default.
+	GobSerializer struct{}
+
+	// JSONSerializer is encoding/json Serializer.
+	// It can decode the struct value loosely into *map[string]interface{} dst. gob can not.
+	JSONSerializer struct{}
+
+	// binarySerializer is the serializer of encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+	// It is preferred over Options.Serializer, e.g. for the protobuf messages.
+	binarySerializer struct{}
+)
+
+const (
+	// SerializerIDRaw is the format ID of string and []byte stored raw.
+	SerializerIDRaw byte = 0
+
+	// SerializerIDGob is GobSerializer's ID.
+	SerializerIDGob byte = 1
+
+	// SerializerIDJSON is JSONSerializer's ID.
+	SerializerIDJSON byte = 2
+
+	// SerializerIDBinary is the format ID of encoding.BinaryMarshaler's value.
+	SerializerIDBinary byte = 3
+
+	// formatMagic never starts a gob stream, and differs from compressMagic.
+	formatMagic = "\x00cv"
+
+	// formatHeaderLen is "<magic><format id><compressor id><meta flags>". The metadata of the flags follows it.
+	formatHeaderLen = len(formatMagic) + 3
+
+	noCompression byte = 0
+)
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[byte]Serializer{
+		SerializerIDGob: &GobSerializer{}, SerializerIDJSON: &JSONSerializer{}, SerializerIDBinary: &binarySerializer{},
+	}
+
+	// ErrSerialized failed to serialize or deserialize except gob. gob's error is ErrGobSerialized.
+	ErrSerialized = errors.New("cachefetcher: serialized failed")
+
+	// ErrSerializerID is the serializer ID reserved for the raw value, or registered to the other type.
+	ErrSerializerID = errors.New("cachefetcher: conflicting serializer id")
+)
+
+// RegisterSerializer registers s as the deserializer of s.ID().
+// Registration is process-global. Options.Serializer, TypeSerializers and DecodeFallbacks are registered automatically.
+// It returns ErrSerializerID for SerializerIDRaw or the ID registered to the other type, e.g. the built-in IDs 1-3,
+// so that s never replaces the decoding of the other factories. The same type is registered again.
+func RegisterSerializer(s Serializer) error {
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	if err := checkSerializerID(s); err != nil {
+		return err
+	}
+	serializers[s.ID()] = s
+	return nil
+}
+
+// checkSerializerID needs serializersMu.
+func checkSerializerID(s Serializer) error {
+	id := s.ID()
+	if id == SerializerIDRaw {
+		return fmt.Errorf("%w: %d is reserved", ErrSerializerID, id)
+	}
+	if r, ok := serializers[id]; ok && reflect.TypeOf(r) != reflect.TypeOf(s) {
+		return fmt.Errorf("%w: %d is registered to %T", ErrSerializerID, id, r)
+	}
+	return nil
+}
+
+// serializerIDCheck returns the check of the serializers registered together in order.
+// It reports whether s is registrable after the serializers checked before it.
+func serializerIDCheck() func(s Serializer) bool {
+	seen := map[byte]reflect.Type{}
+	return func(s Serializer) bool {
+		if s == nil {
+			return true
+		}
+
+		id, t := s.ID(), reflect.TypeOf(s)
+		if st, ok := seen[id]; ok {
+			return st == t
+		}
+
+		serializersMu.RLock()
+		err := checkSerializerID(s)
+		serializersMu.RUnlock()
+		if err != nil {
+			return false
+		}
+		seen[id] = t
+		return true
+	}
+}
+
+func lookupSerializer(id byte) (Serializer, bool) {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+	s, ok := serializers[id]
+	return s, ok
+}
+
+// isSerializedErr reports whether err is the serialization error.
+func isSerializedErr(err error) bool {
+	return errors.Is(err, ErrGobSerialized) || errors.Is(err, ErrSerialized)
+}
+
+// encodeWithHeader returns "<magic><format id><compressor id><meta flags><payload>" without the metadata.
+func (f *cacheFetcherImpl) encodeWithHeader(value interface{}) (string, error) {
+	format := SerializerIDRaw
+	var b []byte
+	switch v := value.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		ser := f.serializer(value)
+		var err error
+		if b, err = ser.Marshal(value); err != nil {
+			return "", err
+		}
+		format = ser.ID()
+	}
+
+	comp := noCompression
+	if c := f.options.Compression; c != nil {
+		var err error
+		if b, err = c.Compress(b); err != nil {
+			return "", fmt.Errorf("%w: %+v", ErrCompression, err)
+		}
+		comp = c.ID()
+	}
+
+	return formatMagic + string([]byte{format, comp, 0}) + string(b), nil
+}
+
+// serializer returns the serializer of value. Options.TypeSerializers is preferred,
+// and encoding.BinaryMarshaler is preferred over Options.Serializer.
+func (f *cacheFetcherImpl) serializer(value interface{}) Serializer {
+	if s, ok := f.typeSerializer(reflect.TypeOf(value)); ok {
+		return s
+	}
+	if _, ok := binaryMarshaler(value); ok {
+		return &binarySerializer{}
+	}
+	return f.options.Serializer
+}
+
+// typeSerializer returns the serializer of t in Options.TypeSerializers. The pointer type is looked up by its element type.
+func (f *cacheFetcherImpl) typeSerializer(t reflect.Type) (Serializer, bool) {
+	if len(f.options.TypeSerializers) == 0 {
+		return nil, false
+	}
+
+	for p := t; p != nil; p = p.Elem() {
+		if s, ok := f.options.TypeSerializers[p]; ok {
+			if p != t {
+				return derefSerializer{Serializer: s, t: p}, true
+			}
+			return s, true
+		}
+		if p.Kind() != reflect.Ptr {
+			break
+		}
+	}
+	return nil, false
+}
+
+// unmarshal deserializes b into dst with ser, then with Options.DecodeFallbacks in order until one succeeds.
+// dst is reset to the zero value before each fallback. The error of ser is returned when all fail.
+func (f *cacheFetcherImpl) unmarshal(ser Serializer, b []byte, dst interface{}) error {
+	err := ser.Unmarshal(b, dst)
+	if err == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	for _, fb := range f.options.DecodeFallbacks {
+		v.Set(reflect.Zero(v.Type()))
+		if fb.Unmarshal(b, dst) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// derefSerializer marshals the pointer value dereferenced to the registered type of Options.TypeSerializers.
+type derefSerializer struct {
+	Serializer
+	t reflect.Type
+}
+
+func (s derefSerializer) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Type() != s.t && rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	return s.Serializer.Marshal(rv.Interface())
+}
+
+// binaryMarshaler returns value as encoding.BinaryMarshaler.
+// The value whose pointer implements it, e.g. the dereferenced fetcher's result, is copied to the pointer.
+func binaryMarshaler(value interface{}) (encoding.BinaryMarshaler, bool) {
+	if m, ok := value.(encoding.BinaryMarshaler); ok {
+		return m, true
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || v.Kind() == reflect.Ptr {
+		return nil, false
+	}
+
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	m, ok := p.Interface().(encoding.BinaryMarshaler)
+	return m, ok
+}
+
+// hasFormatHeader reports whether s is stored with the format header.
+func hasFormatHeader(s string) bool {
+	return len(s) >= formatHeaderLen && strings.HasPrefix(s, formatMagic)
+}
+
+// decodeWithHeader decodes s into dst with the deserializer and the decompressor of the header,
+// then with Options.DecodeFallbacks.
+func (f *cacheFetcherImpl) decodeWithHeader(s string, dst interface{}) error {
+	format, b, err := payloadWithHeader(s)
+	if err != nil {
+		return err
+	}
+
+	if format == SerializerIDRaw {
+		switch d := dst.(type) {
+		case *string:
+			*d = string(b)
+			return nil
+		case *[]byte:
+			*d = b
+			return nil
+		}
+		return fmt.Errorf("%w: raw value into %T", ErrFetchTypeMismatch, dst)
+	}
+
+	ser, ok := lookupSerializer(format)
+	if !ok {
+		return fmt.Errorf("%w: unknown serializer id %d", ErrSerialized, format)
+	}
+	return f.unmarshal(ser, b, dst)
+}
+
+// payloadWithHeader returns the serializer ID of the header and the decompressed payload.
+func payloadWithHeader(s string) (byte, []byte, error) {
+	format, comp, _, p, err := splitHeader(s)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	b := []byte(p)
+	if comp == noCompression {
+		return format, b, nil
+	}
+
+	c, ok := lookupCompressor(comp)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: unknown compressor id %d", ErrCompression, comp)
+	}
+
+	if b, err = c.Decompress(b); err != nil {
+		return 0, nil, fmt.Errorf("%w: %+v", ErrCompression, err)
+	}
+	return format, b, nil
+}
+
+// splitHeader splits s with the format header into the serializer ID, the compressor ID, the metadata and the payload.
+func splitHeader(s string) (format, comp byte, m Meta, payload string, err error) {
+	format, comp, flags := s[len(formatMagic)], s[len(formatMagic)+1], s[len(formatMagic)+2]
+	if flags&^(metaWrittenAt|metaSource) != 0 {
+		return 0, 0, Meta{}, "", fmt.Errorf("%w: unknown meta flags %d", ErrSerialized, flags)
+	}
+
+	payload = s[formatHeaderLen:]
+	if flags&metaWrittenAt != 0 {
+		if len(payload) < writtenAtLen {
+			return 0, 0, Meta{}, "", fmt.Errorf("%w: short write time", ErrSerialized)
+		}
+		m.WrittenAt = unixNanos(payload[:writtenAtLen])
+		payload = payload[writtenAtLen:]
+	}
+
+	if flags&metaSource != 0 {
+		b := []byte(payload)
+		if len(b) > binary.MaxVarintLen64 {
+			b = b[:binary.MaxVarintLen64]
+		}
+		n, l := binary.Uvarint(b)
+		if l <= 0 || uint64(len(payload)-l) < n {
+			return 0, 0, Meta{}, "", fmt.Errorf("%w: short source", ErrSerialized)
+		}
+		m.Source = payload[l : l+int(n)]
+		payload = payload[l+int(n):]
+	}
+	return format, comp, m, payload, nil
+}
+
+// Marshal is gob encode.
+func (s *GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("%w: %T: %+v", ErrGobSerialized, v, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal is gob decode.
+// gob does not distinguish the empty slice from nil, so the slice and the map are decoded as empty, not nil,
+// to round-trip the legitimately empty result.
+func (s *GobSerializer) Unmarshal(b []byte, dst interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(dst); err != nil {
+		return fmt.Errorf("%w: %T: %+v", ErrGobSerialized, dst, err)
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	switch {
+	case v.Kind() == reflect.Slice && v.IsNil():
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	case v.Kind() == reflect.Map && v.IsNil():
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	return nil
+}
+
+// ID is SerializerIDGob.
+func (s *GobSerializer) ID() byte {
+	return SerializerIDGob
+}
+
+// Marshal is json encode.
+func (s *JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %T: %+v", ErrSerialized, v, err)
+	}
+	return b, nil
+}
+
+// Unmarshal is json decode.
+func (s *JSONSerializer) Unmarshal(b []byte, dst interface{}) error {
+	if err := json.Unmarshal(b, dst); err != nil {
+		return fmt.Errorf("%w: %T: %+v", ErrSerialized, dst, err)
+	}
+	return nil
+}
+
+// ID is SerializerIDJSON.
+func (s *JSONSerializer) ID() byte {
+	return SerializerIDJSON
+}
+
+// Marshal is MarshalBinary.
+func (s *binarySerializer) Marshal(v interface{}) ([]byte, error) {
+	m, ok := binaryMarshaler(v)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not encoding.BinaryMarshaler", ErrSerialized, v)
+	}
+
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %T: %+v", ErrSerialized, v, err)
+	}
+	return b, nil
+}
+
+// Unmarshal is UnmarshalBinary.
+func (s *binarySerializer) Unmarshal(b []byte, dst interface{}) error {
+	u, ok := dst.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%w: %T is not encoding.BinaryUnmarshaler", ErrSerialized, dst)
+	}
+
+	if err := u.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("%w: %T: %+v", ErrSerialized, dst, err)
+	}
+	return nil
+}
+
+// ID is SerializerIDBinary.
+func (s *binarySerializer) ID() byte {
+	return SerializerIDBinary
+}
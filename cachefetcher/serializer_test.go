@@ -0,0 +1,603 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// binaryValue is a test value with the binary marshaler, like the protobuf message.
+type binaryValue struct {
+	N int
+}
+
+func (v *binaryValue) MarshalBinary() ([]byte, error) { return []byte("n=" + strconv.Itoa(v.N)), nil }
+
+func (v *binaryValue) UnmarshalBinary(b []byte) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "n="))
+	v.N = n
+	return err
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+	}{
+		{"default", &cachefetcher.Options{}},
+		{"json", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}}},
+		{"header", &cachefetcher.Options{FormatHeader: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "binary", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(&binaryValue{N: 1}, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if v := redisClient.Rdb.Get(ctx, f.Key()).Val(); !strings.HasSuffix(v, "n=1") {
+				t.Errorf("%#v is not marshaled by MarshalBinary", v)
+			}
+
+			var dst binaryValue
+			if err := f.Get(&dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if dst.N != 1 {
+				t.Errorf("%#v is not 1", dst.N)
+			}
+
+			// the fetcher's result is dereferenced, and marshaled by the pointer's MarshalBinary.
+			if err := f.Del(); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst2 binaryValue
+			if err := f.Fetch(10*time.Second, &dst2, func() (*binaryValue, error) { return &binaryValue{N: 2}, nil }); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst3 binaryValue
+			if err := f.Get(&dst3); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if dst2.N != 2 || dst3.N != 2 {
+				t.Errorf("%#v, %#v is not 2", dst2.N, dst3.N)
+			}
+		})
+	}
+}
+
+func TestJSONSerializerMap(t *testing.T) {
+	before()
+
+	for _, header := range []bool{false, true} {
+		f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+			Serializer: &cachefetcher.JSONSerializer{}, FormatHeader: header,
+		}).NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "map", header); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set(&testConcrete{A: 1, B: "a"}, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		// read loosely without the struct.
+		var dst map[string]interface{}
+		if err := f.Get(&dst); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		want := map[string]interface{}{"A": float64(1), "B": "a"}
+		if !reflect.DeepEqual(dst, want) {
+			t.Errorf("%#v is not %#v", dst, want)
+		}
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	before()
+
+	e := &testStruct{I: 1, S: "a", SS: []string{"a", "b"}}
+
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+	}{
+		{"gob", &cachefetcher.Options{FormatHeader: true}},
+		{"json", &cachefetcher.Options{FormatHeader: true, Serializer: &cachefetcher.JSONSerializer{}}},
+		{"json gzip", &cachefetcher.Options{
+			FormatHeader: true, Serializer: &cachefetcher.JSONSerializer{}, Compression: &cachefetcher.GzipCompressor{},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(e, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if v := redisClient.Rdb.Get(ctx, f.Key()).Val(); !strings.HasPrefix(v, "\x00cv") {
+				t.Errorf("%#v has no header", v)
+			}
+
+			var dst testStruct
+			if err := f.Get(&dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !reflect.DeepEqual(dst, *e) {
+				t.Errorf("%#v is not %#v", dst, e)
+			}
+
+			// read by the fetcher without the options, because the header tells the format.
+			f2 := factory.NewFetcher()
+			if err := f2.SetKey([]string{"prefix", "key"}, tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst2 testStruct
+			if err := f2.Get(&dst2); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !reflect.DeepEqual(dst2, *e) {
+				t.Errorf("%#v is not %#v", dst2, e)
+			}
+		})
+	}
+}
+
+func TestFormatHeaderRaw(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{FormatHeader: true}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "raw"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst != "value" {
+		t.Errorf("%#v is not value", dst)
+	}
+
+	var n int
+	if err := f.Get(&n); !errors.Is(err, cachefetcher.ErrFetchTypeMismatch) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestFormatHeaderLegacy(t *testing.T) {
+	before()
+
+	e := &testStruct{I: 1, S: "a", SS: []string{"a", "b"}}
+
+	// the legacy value is written without the header.
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "legacy"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(e, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	hf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		FormatHeader: true, Serializer: &cachefetcher.JSONSerializer{},
+	}).NewFetcher()
+	if err := hf.SetKey([]string{"prefix", "key"}, "legacy"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testStruct
+	if err := hf.Get(&dst); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if !reflect.DeepEqual(dst, *e) {
+		t.Errorf("%#v is not %#v", dst, e)
+	}
+}
+
+func TestFormatHeaderUnknownSerializer(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "unknown"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	redisClient.Rdb.Set(ctx, f.Key(), "\x00cv\xfe\x00\x00value", 10*time.Second)
+
+	var dst testStruct
+	if err := f.Get(&dst); !errors.Is(err, cachefetcher.ErrSerialized) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestRawStringFetch(t *testing.T) {
+	before()
+
+	want := `{"a":1}` // pre-rendered json from the upstream.
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		RawStringFetch: true, FormatHeader: true, Compression: &cachefetcher.GzipCompressor{},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "raw fetch"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if err := f.Fetch(10*time.Second, &dst, func() (string, error) { return want, nil }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if v := redisClient.Rdb.Get(ctx, f.Key()).Val(); v != want {
+		t.Errorf("%#v is not stored raw", v)
+	}
+
+	got, err := f.GetString()
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if got != want {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	// the hit of Fetch reads it raw.
+	var dst2 string
+	if err := f.Fetch(10*time.Second, &dst2, func() (string, error) { return "", errors.New("not called") }); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dst2 != want {
+		t.Errorf("%#v is not %#v", dst2, want)
+	}
+}
+
+// unexportedValue is a test value that gob can not encode.
+type unexportedValue struct {
+	n int
+}
+
+func TestSerializedErrorWithType(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "errtype"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	err := f.Set(unexportedValue{n: 1}, 10*time.Second)
+	if !errors.Is(err, cachefetcher.ErrGobSerialized) || !strings.Contains(err.Error(), "cachefetcher_test.unexportedValue") {
+		t.Errorf("%#v", err)
+	}
+
+	jf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}}).NewFetcher()
+	if err := jf.SetKey([]string{"prefix", "key"}, "errtype"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	redisClient.Rdb.Set(ctx, jf.Key(), "{broken", 10*time.Second)
+
+	var dst testConcrete
+	if err := jf.Get(&dst); !errors.Is(err, cachefetcher.ErrSerialized) || !strings.Contains(err.Error(), "*cachefetcher_test.testConcrete") {
+		t.Errorf("%#v", err)
+	}
+}
+
+// concreteSerializer is a test hand-written codec of testConcrete.
+type concreteSerializer struct{}
+
+func (concreteSerializer) Marshal(v interface{}) ([]byte, error) {
+	c := v.(testConcrete)
+	return []byte(strconv.Itoa(c.A) + "|" + c.B), nil
+}
+
+func (concreteSerializer) Unmarshal(b []byte, dst interface{}) error {
+	s := strings.SplitN(string(b), "|", 2)
+	if len(s) != 2 {
+		return cachefetcher.ErrSerialized
+	}
+
+	n, err := strconv.Atoi(s[0])
+	*dst.(*testConcrete) = testConcrete{A: n, B: s[1]}
+	return err
+}
+
+func (concreteSerializer) ID() byte { return 101 }
+
+// idSerializer is JSONSerializer with the test ID.
+type idSerializer struct {
+	cachefetcher.JSONSerializer
+	id byte
+}
+
+func (s *idSerializer) ID() byte { return s.id }
+
+func TestRegisterSerializer(t *testing.T) {
+	for _, id := range []byte{
+		cachefetcher.SerializerIDRaw, cachefetcher.SerializerIDGob, cachefetcher.SerializerIDJSON, cachefetcher.SerializerIDBinary,
+	} {
+		if err := cachefetcher.RegisterSerializer(&idSerializer{id: id}); !errors.Is(err, cachefetcher.ErrSerializerID) {
+			t.Errorf("%d: %#v", id, err)
+		}
+	}
+
+	// the same type is registered again, e.g. by each NewFactory.
+	if err := cachefetcher.RegisterSerializer(&cachefetcher.JSONSerializer{}); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := cachefetcher.RegisterSerializer(&idSerializer{id: 103}); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := cachefetcher.RegisterSerializer(&idSerializer{id: 103}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// JSON is still decoded by JSONSerializer.
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		Serializer: &cachefetcher.JSONSerializer{}, FormatHeader: true,
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "register"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := f.Set(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	var dst testConcrete
+	if err := f.Get(&dst); err != nil || dst != (testConcrete{A: 1, B: "b"}) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
+
+func TestTypeSerializers(t *testing.T) {
+	before()
+
+	ts := map[reflect.Type]cachefetcher.Serializer{reflect.TypeOf(testConcrete{}): concreteSerializer{}}
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+		stored  string
+	}{
+		{"default", &cachefetcher.Options{TypeSerializers: ts}, "1|b"},
+		{"header", &cachefetcher.Options{TypeSerializers: ts, FormatHeader: true}, "\x00cv\x65\x00\x001|b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "typeserializer", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			for _, v := range []interface{}{testConcrete{A: 1, B: "b"}, &testConcrete{A: 1, B: "b"}} {
+				if err := f.Set(v, 10*time.Second); err != nil {
+					t.Errorf("%#v", err)
+				}
+
+				if s := redisClient.Rdb.Get(ctx, f.Key()).Val(); s != tt.stored {
+					t.Errorf("%#v is not %#v", s, tt.stored)
+				}
+
+				var dst testConcrete
+				if err := f.Get(&dst); err != nil || dst != (testConcrete{A: 1, B: "b"}) {
+					t.Errorf("%#v, %#v", err, dst)
+				}
+			}
+
+			// the other types use the default.
+			if err := f.Set(testStruct{}, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			var dst testStruct
+			if err := f.Get(&dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+		})
+	}
+
+	// the header routes to the serializer without TypeSerializers.
+	hf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{FormatHeader: true}).NewFetcher()
+	if err := hf.SetKey([]string{"prefix", "key"}, "typeserializer", "header"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	tf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{TypeSerializers: ts, FormatHeader: true}).NewFetcher()
+	if err := tf.SetKey([]string{"prefix", "key"}, "typeserializer", "header"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := tf.Set(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testConcrete
+	if err := hf.Get(&dst); err != nil || dst.A != 1 {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+}
+
+func TestDecodeFallbacks(t *testing.T) {
+	before()
+
+	// the legacy value is stored by gob.
+	gf := cachefetcher.NewFactory(redisClient, nil).NewFetcher()
+	if err := gf.SetKey([]string{"prefix", "key"}, "decodefallbacks"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := gf.Set(testConcrete{A: 1, B: "b"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	jf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}}).NewFetcher()
+	if err := jf.SetKey([]string{"prefix", "key"}, "decodefallbacks"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testConcrete
+	if err := jf.Get(&dst); !errors.Is(err, cachefetcher.ErrSerialized) {
+		t.Errorf("%#v", err)
+	}
+
+	ff := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		Serializer:      &cachefetcher.JSONSerializer{},
+		DecodeFallbacks: []cachefetcher.Serializer{&cachefetcher.GobSerializer{}},
+	}).NewFetcher()
+	if err := ff.SetKey([]string{"prefix", "key"}, "decodefallbacks"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	dst = testConcrete{}
+	if err := ff.Get(&dst); err != nil || dst != (testConcrete{A: 1, B: "b"}) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	// the new value is stored by the primary, and read without the fallback.
+	if err := ff.Set(testConcrete{A: 2, B: "c"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if s := redisClient.Rdb.Get(ctx, ff.Key()).Val(); s != `{"A":2,"B":"c"}` {
+		t.Errorf("%#v", s)
+	}
+
+	dst = testConcrete{}
+	if err := ff.Get(&dst); err != nil || dst != (testConcrete{A: 2, B: "c"}) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	// the error of the primary is returned when all fail.
+	if err := redisClient.Rdb.Set(ctx, ff.Key(), "broken", 10*time.Second).Err(); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := ff.Get(&dst); !errors.Is(err, cachefetcher.ErrSerialized) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestDecodeFallbacksWithHeader(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{
+		FormatHeader:    true,
+		DecodeFallbacks: []cachefetcher.Serializer{&cachefetcher.GobSerializer{}},
+	}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "decodefallbacks"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the header of JSONSerializer with the gob payload, e.g. by the buggy writer.
+	b, err := (&cachefetcher.GobSerializer{}).Marshal(testConcrete{A: 1, B: "b"})
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+	redisClient.Rdb.Set(ctx, f.Key(), "\x00cv\x02\x00\x00"+string(b), 10*time.Second)
+
+	var dst testConcrete
+	if err := f.Get(&dst); err != nil || dst != (testConcrete{A: 1, B: "b"}) {
+		t.Errorf("%#v, %#v", err, dst)
+	}
+
+	// the error of the header's serializer is returned without the fallbacks.
+	nf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{FormatHeader: true}).NewFetcher()
+	if err := nf.SetKey([]string{"prefix", "key"}, "decodefallbacks"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := nf.Get(&dst); !errors.Is(err, cachefetcher.ErrSerialized) {
+		t.Errorf("%#v", err)
+	}
+}
+
+// timeValue is a test value with the time field.
+type timeValue struct {
+	At time.Time
+}
+
+// unixMillisSerializer is a test serializer of timeValue that stores the time as the unix millis.
+type unixMillisSerializer struct{}
+
+func (unixMillisSerializer) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strconv.FormatInt(v.(timeValue).At.UnixNano()/int64(time.Millisecond), 10)), nil
+}
+
+func (unixMillisSerializer) Unmarshal(b []byte, dst interface{}) error {
+	ms, err := strconv.ParseInt(string(b), 10, 64)
+	*dst.(*timeValue) = timeValue{At: time.Unix(0, ms*int64(time.Millisecond)).UTC()}
+	return err
+}
+
+func (unixMillisSerializer) ID() byte { return 102 }
+
+func TestTimeValue(t *testing.T) {
+	before()
+
+	// the time with the nanoseconds.
+	now := time.Now().UTC().Truncate(time.Second).Add(123456789)
+	tests := []struct {
+		name    string
+		options *cachefetcher.Options
+		want    time.Time
+		stored  string
+	}{
+		// the nanoseconds are kept.
+		{"gob", &cachefetcher.Options{}, now.Round(0), ""},
+		{"json", &cachefetcher.Options{Serializer: &cachefetcher.JSONSerializer{}}, now.Round(0), `{"At":"` + now.Format(time.RFC3339Nano) + `"}`},
+		{"unix millis", &cachefetcher.Options{TypeSerializers: map[reflect.Type]cachefetcher.Serializer{
+			reflect.TypeOf(timeValue{}): unixMillisSerializer{},
+		}}, now.Truncate(time.Millisecond), strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := cachefetcher.NewFactory(redisClient, tt.options).NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "time", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.Set(timeValue{At: now}, 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if s := redisClient.Rdb.Get(ctx, f.Key()).Val(); tt.stored != "" && s != tt.stored {
+				t.Errorf("%#v is not %#v", s, tt.stored)
+			}
+
+			var dst timeValue
+			if err := f.Get(&dst); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if dst.At != tt.want {
+				t.Errorf("%v is not %v", dst.At, tt.want)
+			}
+		})
+	}
+}
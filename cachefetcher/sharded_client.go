@@ -0,0 +1,237 @@
+package cachefetcher
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ShardedClientImpl is the Client that shards the keys over the standalone clients with the consistent hash ring,
+	// e.g. several Redis instances without Cluster mode. Each key goes to the owning node,
+	// and adding a node moves only the keys that the new node owns.
+	ShardedClientImpl struct {
+		nodes map[string]Client
+		ring  []ringPoint // sorted by hash.
+	}
+
+	ringPoint struct {
+		hash uint32
+		node string
+	}
+)
+
+// NewShardedClient returns ShardedClientImpl of nodes. The node name, e.g. the address, is the identity on the ring,
+// so keep it stable across restarts. virtualNodes is the number of the points of each node for the balance. default is 1.
+// The key with the hash tag, e.g. "svcA_{tag}_key" of Options.HashTag, is routed by the tag like Redis Cluster.
+// The empty nodes and the nil client return ErrInvalidNodes.
+func NewShardedClient(nodes map[string]Client, virtualNodes int) (*ShardedClientImpl, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: no node", ErrInvalidNodes)
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = 1
+	}
+
+	c := &ShardedClientImpl{nodes: make(map[string]Client, len(nodes))}
+	for name, n := range nodes {
+		if n == nil {
+			return nil, fmt.Errorf("%w: nil client of %q", ErrInvalidNodes, name)
+		}
+		c.nodes[name] = n // copy, so that the caller's map can be reused for the next ring.
+		for i := 0; i < virtualNodes; i++ {
+			c.ring = append(c.ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i))), node: name})
+		}
+	}
+
+	sort.Slice(c.ring, func(i, j int) bool {
+		if c.ring[i].hash != c.ring[j].hash {
+			return c.ring[i].hash < c.ring[j].hash
+		}
+		return c.ring[i].node < c.ring[j].node // deterministic on the collision.
+	})
+	return c, nil
+}
+
+// Node returns the name of the node that owns key.
+func (c *ShardedClientImpl) Node(key string) string {
+	h := crc32.ChecksumIEEE([]byte(shardKey(key)))
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0 // wrap around the ring.
+	}
+	return c.ring[i].node
+}
+
+// shardKey returns the hash tag of key if any, or key.
+func shardKey(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+// keySlot returns the Redis Cluster hash slot of key, CRC16 of the hash tag or key.
+func keySlot(key string) uint16 {
+	var crc uint16
+	for _, b := range []byte(shardKey(key)) {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// groupBySlot groups keys by Redis Cluster hash slot.
+func groupBySlot(keys []string) map[uint16][]string {
+	m := map[uint16][]string{}
+	for _, k := range keys {
+		s := keySlot(k)
+		m[s] = append(m[s], k)
+	}
+	return m
+}
+
+func (c *ShardedClientImpl) client(key string) Client {
+	return c.nodes[c.Node(key)]
+}
+
+// groupByNode groups keys by the owning node.
+func (c *ShardedClientImpl) groupByNode(keys []string) map[string][]string {
+	m := map[string][]string{}
+	for _, k := range keys {
+		n := c.Node(k)
+		m[n] = append(m[n], k)
+	}
+	return m
+}
+
+// Set sets to the owning node.
+func (c *ShardedClientImpl) Set(key string, value interface{}, expiration time.Duration) error {
+	return c.client(key).Set(key, value, expiration)
+}
+
+// SetNX sets to the owning node only if the key does not exist.
+func (c *ShardedClientImpl) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	return c.client(key).SetNX(key, value, expiration)
+}
+
+// Get gets from the owning node.
+func (c *ShardedClientImpl) Get(key string, dst interface{}) error {
+	return c.client(key).Get(key, dst)
+}
+
+// MGet gets from each owning node, and merges the results.
+// The node without MultiGetter is got by Get one by one.
+func (c *ShardedClientImpl) MGet(keys []string) (map[string]string, error) {
+	m := make(map[string]string, len(keys))
+	for n, ks := range c.groupByNode(keys) {
+		node := c.nodes[n]
+		vals, err := getMulti(node, ks, node.IsErrCacheMiss)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range vals {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+// Del deletes from the owning node.
+func (c *ShardedClientImpl) Del(key string) error {
+	return c.client(key).Del(key)
+}
+
+// DelMulti deletes from each owning node, and returns the total of the deleted keys.
+func (c *ShardedClientImpl) DelMulti(keys []string) (int64, error) {
+	var total int64
+	for n, ks := range c.groupByNode(keys) {
+		deleted, err := c.nodes[n].DelMulti(ks)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Exists checks the owning node.
+func (c *ShardedClientImpl) Exists(key string) (bool, error) {
+	return c.client(key).Exists(key)
+}
+
+// Scan fans out to all nodes, and concatenates the keys.
+func (c *ShardedClientImpl) Scan(match string) ([]string, error) {
+	var keys []string
+	for _, n := range c.nodes {
+		k, err := n.Scan(match)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+// HSet sets the hash to the owning node.
+func (c *ShardedClientImpl) HSet(key string, fields map[string]string, expiration time.Duration) error {
+	return c.client(key).HSet(key, fields, expiration)
+}
+
+// HGetAll gets the hash from the owning node.
+func (c *ShardedClientImpl) HGetAll(key string) (map[string]string, error) {
+	return c.client(key).HGetAll(key)
+}
+
+// HGet gets the hash field from the owning node.
+func (c *ShardedClientImpl) HGet(key, field string) (string, error) {
+	return c.client(key).HGet(key, field)
+}
+
+// Ping pings all nodes. The Client not implementing Pinger is skipped.
+func (c *ShardedClientImpl) Ping(ctx context.Context) error {
+	for _, n := range c.nodes {
+		if p, ok := n.(Pinger); ok {
+			if err := p.Ping(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes all nodes, and returns the first error. The Client not implementing Closer is skipped.
+func (c *ShardedClientImpl) Close() error {
+	var first error
+	for _, n := range c.nodes {
+		if cl, ok := n.(Closer); ok {
+			if err := cl.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// IsErrCacheMiss reports whether any node decides err is the cache miss.
+func (c *ShardedClientImpl) IsErrCacheMiss(err error) bool {
+	for _, n := range c.nodes {
+		if n.IsErrCacheMiss(err) {
+			return true
+		}
+	}
+	return false
+}
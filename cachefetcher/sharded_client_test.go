@@ -0,0 +1,125 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+// recordingClient is a test client that records the set keys.
+type recordingClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+	keys map[string]bool
+}
+
+func (c *recordingClient) Set(key string, value interface{}, expiration time.Duration) error {
+	c.keys[key] = true
+	return c.SimpleRedisClientImpl.Set(key, value, expiration)
+}
+
+func TestShardedClient(t *testing.T) {
+	before()
+
+	nodes := map[string]*recordingClient{}
+	clients := map[string]cachefetcher.Client{}
+	for _, n := range []string{"a", "b", "c"} {
+		nodes[n] = &recordingClient{SimpleRedisClientImpl: redisClient, keys: map[string]bool{}}
+		clients[n] = nodes[n]
+	}
+	client, err := cachefetcher.NewShardedClient(clients, 100)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	f := cachefetcher.NewFactory(client, nil).NewFetcher()
+	var keys []string
+	for i := 0; i < 30; i++ {
+		if err := f.SetKey([]string{"prefix", "key"}, "shard", i); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if err := f.Set(i, 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+		keys = append(keys, f.Key())
+
+		for n, c := range nodes {
+			if c.keys[f.Key()] != (n == client.Node(f.Key())) {
+				t.Errorf("%#v is set to %#v, owner is %#v", f.Key(), n, client.Node(f.Key()))
+			}
+		}
+	}
+
+	for n, c := range nodes {
+		if len(c.keys) == 0 {
+			t.Errorf("%#v has no keys", n)
+		}
+	}
+
+	m, err := client.MGet(keys)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if len(m) != len(keys) {
+		t.Errorf("%#v is not %#v", len(m), len(keys))
+	}
+
+	// the hash tag is routed by the tag.
+	if client.Node("svcA_{user:1}_a") != client.Node("svcA_{user:1}_b") {
+		t.Errorf("the hash tag is not routed to the same node")
+	}
+}
+
+func TestShardedClientAddNode(t *testing.T) {
+	clients := map[string]cachefetcher.Client{"a": redisClient, "b": redisClient, "c": redisClient}
+	c3, err := cachefetcher.NewShardedClient(clients, 100)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	clients["d"] = redisClient
+	c4, err := cachefetcher.NewShardedClient(clients, 100)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := "prefix_key_" + strconv.Itoa(i)
+		if c3.Node(key) != c4.Node(key) {
+			moved++
+			// only the keys that the new node owns are moved.
+			if c4.Node(key) != "d" {
+				t.Errorf("%#v is moved to %#v", key, c4.Node(key))
+			}
+		}
+	}
+
+	// about a quarter is moved to the new node.
+	if moved == 0 || moved > 500 {
+		t.Errorf("%#v keys are moved", moved)
+	}
+}
+
+func TestShardedClientInvalidNodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes map[string]cachefetcher.Client
+	}{
+		{"nil", nil},
+		{"empty", map[string]cachefetcher.Client{}},
+		{"nil client", map[string]cachefetcher.Client{"a": redisClient, "b": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := cachefetcher.NewShardedClient(tt.nodes, 0); !errors.Is(err, cachefetcher.ErrInvalidNodes) || c != nil {
+				t.Errorf("%#v, %#v", c, err)
+			}
+		})
+	}
+}
@@ -9,7 +9,36 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-var ctx = context.Background()
+var (
+	ctx = context.Background()
+
+	// delIfEqualsScript deletes KEYS[1] only if the value equals ARGV[1].
+	delIfEqualsScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	// setIfNewerScript sets KEYS[1] to ARGV[1] and the version KEYS[2] to ARGV[2] only if ARGV[2] is greater.
+	// ARGV[3] is the expiration in milliseconds. 0 is no expiration.
+	setIfNewerScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[2])
+if v and tonumber(v) >= tonumber(ARGV[2]) then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1
+`)
+)
+
+const scanCount = 100
 
 // SimpleRedisClientImpl is a sample redisClient implementation.
 type SimpleRedisClientImpl struct {
@@ -22,6 +51,12 @@ func (i *SimpleRedisClientImpl) Set(key string, value interface{}, expiration ti
 	return i.Rdb.Set(ctx, key, value, expiration).Err()
 }
 
+// SetNX is an implementation of the function in the sample redisClient.
+// It sets only if the key does not exist.
+func (i *SimpleRedisClientImpl) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	return i.Rdb.SetNX(ctx, key, value, expiration).Result()
+}
+
 // Get is an implementation of the function in the sample redisClient.
 func (i *SimpleRedisClientImpl) Get(key string, dst interface{}) error {
 	// You need an implementation to get from the cache.
@@ -34,11 +69,241 @@ func (i *SimpleRedisClientImpl) Get(key string, dst interface{}) error {
 	return nil
 }
 
+// GetWithTTL is an implementation of the function in the sample redisClient.
+// It runs GET and TTL atomically in the transaction.
+func (i *SimpleRedisClientImpl) GetWithTTL(key string, dst interface{}) (time.Duration, error) {
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	if _, err := i.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		ttl = pipe.TTL(ctx, key)
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	if err := get.Err(); err != nil {
+		return 0, err
+	}
+
+	reflect.ValueOf(dst).Elem().SetString(get.Val())
+	return ttl.Val(), nil
+}
+
+// MGet is an implementation of the function in the sample redisClient.
+// It returns only the existing keys.
+func (i *SimpleRedisClientImpl) MGet(keys []string) (map[string]string, error) {
+	vals, err := i.Rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(vals))
+	for n, v := range vals {
+		if s, ok := v.(string); ok {
+			m[keys[n]] = s
+		}
+	}
+	return m, nil
+}
+
 // Del is an implementation of the function in the sample redisClient.
 func (i *SimpleRedisClientImpl) Del(key string) error {
 	return i.Rdb.Del(ctx, key).Err()
 }
 
+// DelMulti is an implementation of the function in the sample redisClient.
+// It deletes keys with a single DEL, and returns the number of the deleted keys.
+func (i *SimpleRedisClientImpl) DelMulti(keys []string) (int64, error) {
+	return i.Rdb.Del(ctx, keys...).Result()
+}
+
+// DelIfEquals is an implementation of the function in the sample redisClient.
+// It runs GET, compare and DEL atomically with the lua script.
+func (i *SimpleRedisClientImpl) DelIfEquals(key string, value interface{}) (bool, error) {
+	n, err := delIfEqualsScript.Run(ctx, i.Rdb, []string{key}, value).Int()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SAdd is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) SAdd(key string, members []string) error {
+	return i.Rdb.SAdd(ctx, key, members).Err()
+}
+
+// SMembers is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) SMembers(key string) ([]string, error) {
+	return i.Rdb.SMembers(ctx, key).Result()
+}
+
+// RPush is an implementation of the function in the sample redisClient.
+// It runs RPUSH and EXPIRE of the whole list atomically in the transaction.
+func (i *SimpleRedisClientImpl) RPush(key string, values []interface{}, expiration time.Duration) error {
+	_, err := i.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, values...)
+		if expiration > 0 {
+			pipe.Expire(ctx, key, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// LRange is an implementation of the function in the sample redisClient.
+// It reads the whole list.
+func (i *SimpleRedisClientImpl) LRange(key string) ([]string, error) {
+	return i.Rdb.LRange(ctx, key, 0, -1).Result()
+}
+
+// SetIfNewer is an implementation of the function in the sample redisClient.
+// It compares the version and sets atomically with the lua script.
+func (i *SimpleRedisClientImpl) SetIfNewer(key, versionKey string, value interface{}, version int64, expiration time.Duration) (bool, error) {
+	n, err := setIfNewerScript.Run(ctx, i.Rdb, []string{key, versionKey}, value, version, expiration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Exists is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) Exists(key string) (bool, error) {
+	n, err := i.Rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Scan is an implementation of the function in the sample redisClient.
+// It iterates SCAN with the cursor not to block redis.
+func (i *SimpleRedisClientImpl) Scan(match string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		k, c, err := i.Rdb.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k...)
+		if cursor = c; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// HSet is an implementation of the function in the sample redisClient.
+// It replaces the whole hash with fields.
+func (i *SimpleRedisClientImpl) HSet(key string, fields map[string]string, expiration time.Duration) error {
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = v
+	}
+
+	_, err := i.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(values) == 0 {
+			return nil
+		}
+		pipe.HSet(ctx, key, values)
+		if expiration > 0 {
+			pipe.Expire(ctx, key, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// HGetAll is an implementation of the function in the sample redisClient.
+// It returns redis.Nil if the key does not exist.
+func (i *SimpleRedisClientImpl) HGetAll(key string) (map[string]string, error) {
+	m, err := i.Rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, redis.Nil
+	}
+	return m, nil
+}
+
+// HGet is an implementation of the function in the sample redisClient.
+// It returns redis.Nil if the key or the field does not exist.
+func (i *SimpleRedisClientImpl) HGet(key, field string) (string, error) {
+	return i.Rdb.HGet(ctx, key, field).Result()
+}
+
+// Append is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) Append(key string, b []byte) error {
+	return i.Rdb.Append(ctx, key, string(b)).Err()
+}
+
+// GetRange is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) GetRange(key string, start, end int64) ([]byte, error) {
+	return i.Rdb.GetRange(ctx, key, start, end).Bytes()
+}
+
+// Rename is an implementation of the function in the sample redisClient.
+// It renames and sets expiration atomically.
+func (i *SimpleRedisClientImpl) Rename(key, newKey string, expiration time.Duration) error {
+	_, err := i.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Rename(ctx, key, newKey)
+		if expiration > 0 {
+			pipe.Expire(ctx, newKey, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// Pipelined is an implementation of the function in the sample redisClient.
+// It sends ops in one round trip, and sets each op's Result and Err.
+func (i *SimpleRedisClientImpl) Pipelined(ops []*PipelineOp) error {
+	gets := map[*PipelineOp]*redis.StringCmd{}
+	errs := map[*PipelineOp]interface{ Err() error }{}
+	_, err := i.Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, op := range ops {
+			switch op.Cmd {
+			case PipelineCmdSet:
+				errs[op] = pipe.Set(ctx, op.Key, op.Value, op.Expiration)
+			case PipelineCmdGet:
+				gets[op] = pipe.Get(ctx, op.Key)
+			case PipelineCmdDel:
+				errs[op] = pipe.Del(ctx, op.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err // the first failed command's error. redis.Nil is per Get.
+	}
+
+	for op, cmd := range gets {
+		op.Result, op.Err = cmd.Result()
+	}
+	for op, cmd := range errs {
+		op.Err = cmd.Err()
+	}
+	return nil
+}
+
+// Ping is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) Ping(ctx context.Context) error {
+	return i.Rdb.Ping(ctx).Err()
+}
+
+// Expire is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) Expire(key string, expiration time.Duration) error {
+	return i.Rdb.Expire(ctx, key, expiration).Err()
+}
+
+// Close is an implementation of the function in the sample redisClient.
+func (i *SimpleRedisClientImpl) Close() error {
+	return i.Rdb.Close()
+}
+
 // IsErrCacheMiss is an implementation of the function in the sample redisClient.
 // Please return the decision at the time of cache miss err.
 func (i *SimpleRedisClientImpl) IsErrCacheMiss(err error) bool {
@@ -0,0 +1,130 @@
+package cachefetcher
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Stats is the snapshot of the counters of the fetchers sharing the same Options.
+	Stats struct {
+		Hits   uint64 // the cached read operations. e.g. Get and Fetch.
+		Misses uint64 // the read operations without cache.
+		Sets   uint64 // the stored values including the set in Fetch.
+		Dels   uint64 // the successful delete operations.
+		Errors uint64 // the operation errors and the handled errors e.g. the decode error treated as cache miss.
+
+		Breaker BreakerState // the state of the circuit breaker. empty if Options.BreakerThreshold is 0.
+	}
+
+	// stats is the atomic counters held in Options.
+	stats struct {
+		hits, misses, sets, dels, errors uint64
+
+		vars *expvar.Map // the counters published with Options.ExpvarName.
+	}
+)
+
+// expvarMu guards the check and the publish of expvar, because expvar.Publish panics on the duplicate name.
+var expvarMu sync.Mutex
+
+var (
+	// statsReadOps are the operations that count hits and misses.
+	statsReadOps = map[string]bool{
+		"Get":              true,
+		"GetWithTTL":       true,
+		"GetString":        true,
+		"GetBytes":         true,
+		"GetReader":        true,
+		"GetHash":          true,
+		"GetHashField":     true,
+		"GetList":          true,
+		"GetInto":          true,
+		"Exists":           true,
+		"FetchWithContext": true,
+		"LockedFetch":      true,
+		"GetOrSet":         true,
+	}
+
+	// statsDelOps are the operations that count dels.
+	statsDelOps = map[string]bool{
+		"Del":           true,
+		"DelIfEquals":   true,
+		"DelKeys":       true,
+		"InvalidateTag": true,
+	}
+)
+
+// IsReadOp reports whether op of Event counts the hits and the misses, e.g. for the metrics of DebugPrintHook.
+// op is the method name, e.g. "Get", or Event.Op.
+func IsReadOp(op string) bool {
+	return statsReadOps[op[strings.LastIndex(op, ".")+1:]]
+}
+
+// Stats returns the snapshot of the counters.
+// The counters are shared across the fetchers of the factories created with the same Options.
+func (f *cacheFetcherImpl) Stats() Stats {
+	s := f.options.stats
+	st := Stats{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+		Sets:   atomic.LoadUint64(&s.sets),
+		Dels:   atomic.LoadUint64(&s.dels),
+		Errors: atomic.LoadUint64(&s.errors),
+	}
+	if f.options.breaker != nil {
+		st.Breaker = f.options.breaker.state()
+	}
+	return st
+}
+
+// count counts the event.
+func (s *stats) count(e Event) {
+	op := e.Op[strings.LastIndex(e.Op, ".")+1:]
+
+	switch {
+	case e.Err != nil:
+		s.add(&s.errors, "errors")
+	case statsDelOps[op]:
+		s.add(&s.dels, "dels")
+	case !IsReadOp(op):
+	case e.IsCached:
+		s.add(&s.hits, "hits")
+	default:
+		s.add(&s.misses, "misses")
+	}
+}
+
+// countSet counts the stored value.
+func (s *stats) countSet() {
+	s.add(&s.sets, "sets")
+}
+
+// add increments the counter, and the published counter of name.
+func (s *stats) add(c *uint64, name string) {
+	atomic.AddUint64(c, 1)
+	if s.vars != nil {
+		s.vars.Add(name, 1)
+	}
+}
+
+// publishExpvar returns expvar.Map published as name. The map of the same name is shared,
+// so the factories with the same name add to the same counters. The other type of var returns nil.
+func publishExpvar(name string) *expvar.Map {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if v := expvar.Get(name); v != nil {
+		m, _ := v.(*expvar.Map)
+		return m
+	}
+
+	m := new(expvar.Map)
+	for _, c := range []string{"hits", "misses", "sets", "dels", "errors"} {
+		m.Add(c, 0)
+	}
+	expvar.Publish(name, m)
+	return m
+}
@@ -0,0 +1,228 @@
+package cachefetcher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Streamer is optional for Client to stream a large value in chunks without buffering the whole value.
+type Streamer interface {
+	Append(key string, b []byte) error
+	GetRange(key string, start, end int64) ([]byte, error) // end is inclusive.
+	Rename(key, newKey string, expiration time.Duration) error
+}
+
+const (
+	streamSuffix    = "stream"
+	streamChunkSize = 1 << 20
+)
+
+var (
+	// ErrNotStreamer is the client does not implement Streamer.
+	ErrNotStreamer = errors.New("cachefetcher: client is not streamer")
+
+	// ErrStreamSize is SetReader's read size is not the size.
+	ErrStreamSize = errors.New("cachefetcher: stream size mismatch")
+)
+
+type streamReader struct {
+	f   *cacheFetcherImpl
+	c   Streamer
+	key string
+	off int64
+	buf []byte
+	eof bool
+}
+
+// SetReader sets the bytes of r as the raw value without serialization and compression.
+// r is appended in chunks to the temporary key "{<key>}_stream_<random token>", and it is renamed to the key at the end,
+// so the readers never see the partial value, and the concurrent SetReader of the same key does not mix the chunks.
+// The hash tag keeps the temporary key in the slot of the key, because RENAME of Redis Cluster needs the same slot.
+// The key with its own hash tag, e.g. "{user}_1", is not wrapped. The memory is one chunk, not the whole value.
+// size is the bytes of r. A negative size is not checked.
+// The client must implement Streamer.
+func (f *cacheFetcherImpl) SetReader(r io.Reader, size int64, expiration time.Duration) error {
+	start := f.options.Clock.Now()
+	if err := f.setReader(r, size, expiration); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) setReader(r io.Reader, size int64, expiration time.Duration) error {
+	f.setCached(false)
+	expiration, err := f.resolveExpiration(expiration)
+	if err != nil {
+		return err
+	}
+
+	c, ok := f.client.(Streamer)
+	if !ok {
+		return ErrNotStreamer
+	}
+
+	tmpKey, err := streamTmpKey(f.key)
+	if err != nil {
+		return err
+	}
+
+	// the temporary key is unique, so the failed one is deleted not to be left.
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = f.withClientTimeout(func() error { return f.client.Del(tmpKey) })
+		}
+	}()
+
+	var n int64
+	buf := make([]byte, streamChunkSize)
+	for {
+		m, rerr := io.ReadFull(r, buf)
+		if m > 0 || n == 0 {
+			// the first append creates the key even if r is empty.
+			b := buf[:m]
+			if err := f.withClientTimeout(func() error { return c.Append(tmpKey, b) }); err != nil {
+				return err
+			}
+		}
+		n += int64(m)
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+
+	if size >= 0 && n != size {
+		return fmt.Errorf("%w: read %d bytes, size %d", ErrStreamSize, n, size)
+	}
+
+	if err := f.withClientTimeout(func() error { return c.Rename(tmpKey, f.key, expiration) }); err != nil {
+		return err
+	}
+	renamed = true
+
+	f.setCached(true)
+	f.setDone(f.key, r) // the value is streamed, so Options.OnSet gets the consumed reader.
+	return nil
+}
+
+// streamTmpKey returns the unique temporary key of SetReader in the Redis Cluster slot of key.
+func streamTmpKey(key string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	if hasHashTag(key) {
+		return key + sep + streamSuffix + sep + token, nil
+	}
+	return "{" + key + "}" + sep + streamSuffix + sep + token, nil
+}
+
+// randomToken returns the random hex token of 8 bytes, e.g. the owner of the lock.
+func randomToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hasHashTag reports whether key has the hash tag of Redis Cluster, the non-empty "{...}" from the first "{".
+func hasHashTag(key string) bool {
+	i := strings.IndexByte(key, '{')
+	return i >= 0 && strings.IndexByte(key[i+1:], '}') > 0
+}
+
+// GetReader gets the raw value as the reader that reads in chunks with GETRANGE.
+// A miss returns the client's cache miss error. The value replaced while reading may be read partially mixed.
+// The client must implement Streamer.
+func (f *cacheFetcherImpl) GetReader() (io.ReadCloser, error) {
+	start := f.options.Clock.Now()
+	r, err := f.getReader()
+	if err != nil {
+		return nil, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (f *cacheFetcherImpl) getReader() (io.ReadCloser, error) {
+	f.setCached(false)
+
+	c, ok := f.client.(Streamer)
+	if !ok {
+		return nil, ErrNotStreamer
+	}
+
+	r := &streamReader{f: f, c: c, key: f.key}
+	if err := r.fill(); err != nil {
+		return nil, err
+	}
+
+	if len(r.buf) == 0 {
+		// GETRANGE can not tell the empty value from a miss. GET is cheap for the empty value.
+		var s string
+		if err := f.withClientTimeout(func() error { return f.client.Get(f.key, &s) }); err != nil {
+			return nil, err
+		}
+	}
+
+	f.setCached(true)
+	return r, nil
+}
+
+func (r *streamReader) fill() error {
+	var b []byte
+	err := r.f.withClientTimeout(func() (err error) {
+		b, err = r.c.GetRange(r.key, r.off, r.off+streamChunkSize-1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.buf = b
+	r.off += int64(len(b))
+	r.eof = len(b) < streamChunkSize
+	return nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+		if len(r.buf) == 0 {
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.buf = nil
+	r.eof = true
+	return nil
+}
@@ -0,0 +1,143 @@
+package cachefetcher_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestSetReaderGetReader(t *testing.T) {
+	before()
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", []byte{}},
+		{"small", []byte("abc")},
+		{"chunks", bytes.Repeat([]byte("0123456789"), 250000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := factory.NewFetcher()
+			if err := f.SetKey([]string{"prefix", "key"}, "stream", tt.name); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if err := f.SetReader(bytes.NewReader(tt.b), int64(len(tt.b)), 10*time.Second); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			r, err := f.GetReader()
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if !bytes.Equal(got, tt.b) {
+				t.Errorf("%d bytes is not %d bytes", len(got), len(tt.b))
+			}
+
+			if !f.IsCached() {
+				t.Errorf("%#v", f.IsCached())
+			}
+		})
+	}
+}
+
+func TestSetReaderError(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "stream"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := f.GetReader(); !errors.Is(err, redis.Nil) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.SetReader(bytes.NewReader([]byte("abc")), 10, 10*time.Second); !errors.Is(err, cachefetcher.ErrStreamSize) {
+		t.Errorf("%#v", err)
+	}
+
+	// the partial value is not set.
+	if ok, err := f.Exists(); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+
+	f = cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if _, err := f.GetReader(); !errors.Is(err, cachefetcher.ErrNotStreamer) {
+		t.Errorf("%#v", err)
+	}
+}
+
+// gateReader signals the first read, and blocks it until the gate is closed.
+type gateReader struct {
+	entered, gate chan struct{}
+	r             io.Reader
+}
+
+func (r *gateReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.entered:
+	default:
+		close(r.entered)
+	}
+	<-r.gate
+	return r.r.Read(p)
+}
+
+func TestSetReaderConcurrent(t *testing.T) {
+	before()
+
+	var fs []cachefetcher.CacheFetcher
+	for i := 0; i < 2; i++ {
+		f := factory.NewFetcher()
+		if err := f.SetKey([]string{"prefix", "key"}, "stream", "concurrent"); err != nil {
+			t.Errorf("%#v", err)
+		}
+		fs = append(fs, f)
+	}
+
+	// a appends the first chunk and waits, while b sets the whole value.
+	chunk := 1 << 20
+	a := append(bytes.Repeat([]byte("a"), chunk), bytes.Repeat([]byte("A"), chunk)...)
+	gr := &gateReader{entered: make(chan struct{}), gate: make(chan struct{}), r: bytes.NewReader(a[chunk:])}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fs[0].SetReader(io.MultiReader(bytes.NewReader(a[:chunk]), gr), int64(len(a)), 10*time.Second)
+	}()
+	<-gr.entered
+
+	b := []byte("bbb")
+	if err := fs[1].SetReader(bytes.NewReader(b), int64(len(b)), 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+	close(gr.gate)
+	if err := <-errs; err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	// the last renamed value is whole.
+	if s := redisClient.Rdb.Get(ctx, fs[0].Key()).Val(); s != string(a) {
+		t.Errorf("%d bytes is not %d bytes", len(s), len(a))
+	}
+
+	// the temporary keys are not left.
+	if keys, err := redisClient.Scan("{*"); err != nil || len(keys) != 0 {
+		t.Errorf("%#v, %#v", keys, err)
+	}
+}
@@ -0,0 +1,108 @@
+package cachefetcher
+
+import "errors"
+
+// Tagger is optional for Client to record the reverse index of the tag with the set.
+type Tagger interface {
+	SAdd(key string, members []string) error
+	SMembers(key string) ([]string, error)
+}
+
+const tagSuffix = "tag"
+
+// ErrNotTagger is the client does not implement Tagger.
+var ErrNotTagger = errors.New("cachefetcher: client is not tagger")
+
+// SetKeyWithTags is SetKey that records the key in the reverse index of each tag, "<KeyPrefix>_tag\x00<tag>".
+// InvalidateTag deletes the keys of the tag, e.g. when the keys do not share a prefix but share an owner entity.
+// The index has no expiration, and it is deleted by InvalidateTag.
+// The client must implement Tagger.
+func (f *cacheFetcherImpl) SetKeyWithTags(prefixes, tags []string, elements ...interface{}) error {
+	start := f.options.Clock.Now()
+	if err := f.setKeyWithTags(prefixes, tags, elements); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) setKeyWithTags(prefixes, tags []string, elements []interface{}) error {
+	c, ok := f.client.(Tagger)
+	if !ok {
+		return ErrNotTagger
+	}
+
+	if err := f.setKey(prefixes, elements, false); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		tk := f.tagKey(tag)
+		if err := f.withClientTimeout(func() error { return c.SAdd(tk, []string{f.key}) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTag deletes the keys recorded by SetKeyWithTags with tag, and the index of tag.
+// The keys are deleted by Redis Cluster slot not to fail with CROSSSLOT, and the index is deleted last.
+// The key recorded while deleting may be dropped from the index without being deleted.
+// The client must implement Tagger.
+func (f *cacheFetcherImpl) InvalidateTag(tag string) error {
+	start := f.options.Clock.Now()
+	if err := f.invalidateTag(tag); err != nil {
+		return f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f *cacheFetcherImpl) invalidateTag(tag string) error {
+	c, ok := f.client.(Tagger)
+	if !ok {
+		return ErrNotTagger
+	}
+
+	tk := f.tagKey(tag)
+	var keys []string
+	err := f.withKeysClientTimeout(func() (err error) {
+		keys, err = c.SMembers(tk)
+		return err
+	})
+	if f.isErrOtherThanCacheMiss(err) {
+		return err
+	}
+
+	for _, ks := range groupBySlot(keys) {
+		ks := ks
+		err := f.withKeysClientTimeout(func() error {
+			_, err := f.client.DelMulti(ks)
+			return err
+		})
+		if f.isErrOtherThanCacheMiss(err) {
+			return err
+		}
+	}
+
+	err = f.withKeysClientTimeout(func() error { return f.client.Del(tk) })
+	if f.isErrOtherThanCacheMiss(err) {
+		return err
+	}
+	return nil
+}
+
+// tagKey returns the key of the reverse index of tag. It is reserved, so it does not collide with the keys of SetKey.
+func (f *cacheFetcherImpl) tagKey(tag string) string {
+	k := tagSuffix + reservedSep + tag
+	if f.options.KeyPrefix != "" {
+		k = f.options.KeyPrefix + sep + k
+	}
+	return k
+}
@@ -0,0 +1,140 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	before()
+
+	f1 := factory.NewFetcher()
+	if err := f1.SetKeyWithTags([]string{"user"}, []string{"owner:1"}, 1); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	f2 := factory.NewFetcher()
+	if err := f2.SetKeyWithTags([]string{"post"}, []string{"owner:1", "owner:2"}, 10); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	f3 := factory.NewFetcher()
+	if err := f3.SetKeyWithTags([]string{"post"}, []string{"owner:2"}, 20); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	for _, f := range []cachefetcher.CacheFetcher{f1, f2, f3} {
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+
+	if err := f1.InvalidateTag("owner:1"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	for _, tt := range []struct {
+		f    cachefetcher.CacheFetcher
+		want bool
+	}{{f1, false}, {f2, false}, {f3, true}} {
+		ok, err := tt.f.Exists()
+		if err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if ok != tt.want {
+			t.Errorf("%#v: %#v is not %#v", tt.f.Key(), ok, tt.want)
+		}
+	}
+
+	// the invalidated tag and the unknown tag are not error.
+	for _, tag := range []string{"owner:1", "unknown"} {
+		if err := f1.InvalidateTag(tag); err != nil {
+			t.Errorf("%#v", err)
+		}
+	}
+}
+
+// crossSlotClient is a test client whose DelMulti fails like CROSSSLOT of Redis Cluster
+// unless the keys share the hash tag.
+type crossSlotClient struct {
+	*cachefetcher.SimpleRedisClientImpl
+}
+
+func (c *crossSlotClient) DelMulti(keys []string) (int64, error) {
+	for _, k := range keys {
+		if hashTag(k) == "" && len(keys) > 1 || hashTag(k) != hashTag(keys[0]) {
+			return 0, errors.New("CROSSSLOT")
+		}
+	}
+	return c.SimpleRedisClientImpl.DelMulti(keys)
+}
+
+func hashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return ""
+}
+
+func TestInvalidateTagCluster(t *testing.T) {
+	before()
+
+	cf := cachefetcher.NewFactory(&crossSlotClient{redisClient}, &cachefetcher.Options{
+		HashTag: func(key string) string { return key[strings.LastIndex(key, "_")+1:] },
+	})
+
+	var fs []cachefetcher.CacheFetcher
+	for _, id := range []int{1, 10, 20} {
+		f := cf.NewFetcher()
+		if err := f.SetKeyWithTags([]string{"post"}, []string{"owner:1"}, id); err != nil {
+			t.Errorf("%#v", err)
+		}
+		if err := f.Set("value", 10*time.Second); err != nil {
+			t.Errorf("%#v", err)
+		}
+		fs = append(fs, f)
+	}
+
+	// the key of SetKey does not collide with the index.
+	other := cf.NewFetcher()
+	if err := other.SetKey([]string{"tag"}, "owner:1"); err != nil {
+		t.Errorf("%#v", err)
+	}
+	if err := other.Set("value", 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := fs[0].InvalidateTag("owner:1"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	for _, f := range fs {
+		if ok, err := f.Exists(); err != nil || ok {
+			t.Errorf("%#v: %#v, %#v", f.Key(), ok, err)
+		}
+	}
+
+	if ok, err := other.Exists(); err != nil || !ok {
+		t.Errorf("%#v: %#v, %#v", other.Key(), ok, err)
+	}
+}
+
+func TestInvalidateTagNotTagger(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if err := f.SetKeyWithTags([]string{"user"}, []string{"owner:1"}, 1); !errors.Is(err, cachefetcher.ErrNotTagger) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.InvalidateTag("owner:1"); !errors.Is(err, cachefetcher.ErrNotTagger) {
+		t.Errorf("%#v", err)
+	}
+}
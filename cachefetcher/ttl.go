@@ -0,0 +1,65 @@
+package cachefetcher
+
+import (
+	"errors"
+	"time"
+)
+
+// TTLGetter is optional for Client to get the stored value and its remaining expiration atomically.
+type TTLGetter interface {
+	GetWithTTL(key string, dst interface{}) (time.Duration, error)
+}
+
+// ErrNotTTLGetter is the client does not implement TTLGetter.
+var ErrNotTTLGetter = errors.New("cachefetcher: client is not ttl getter")
+
+// GetWithTTL is Get that also returns the remaining expiration of the cache, e.g. for Cache-Control max-age.
+// The value and the expiration are read atomically in one round trip, so the expiration is not changed between them.
+// The cache without expiration returns 0. A miss returns the client's cache miss error.
+// It does not use singleflight. The client must implement TTLGetter.
+func (f *cacheFetcherImpl) GetWithTTL(dst interface{}) (time.Duration, error) {
+	start := f.options.Clock.Now()
+	ttl, err := f.getWithTTL(dst)
+	if err != nil {
+		return 0, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (f *cacheFetcherImpl) getWithTTL(dst interface{}) (time.Duration, error) {
+	f.setCached(false)
+
+	c, ok := f.client.(TTLGetter)
+	if !ok {
+		return 0, ErrNotTTLGetter
+	}
+
+	if err := f.checkDst(dst); err != nil {
+		return 0, err
+	}
+
+	var s string
+	var ttl time.Duration
+	err := f.withClientTimeout(func() (err error) {
+		ttl, err = c.GetWithTTL(f.key, &s)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := f.decode(s, dst, false); err != nil {
+		return 0, err
+	}
+
+	if ttl < 0 {
+		ttl = 0 // no expiration.
+	}
+
+	f.setCached(true)
+	return ttl, nil
+}
@@ -0,0 +1,63 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestGetWithTTL(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "ttl"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst testConcrete
+	if _, err := f.GetWithTTL(&dst); !redisClient.IsErrCacheMiss(err) {
+		t.Errorf("%#v", err)
+	}
+
+	if err := f.Set(&testConcrete{A: 1, B: "a"}, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	ttl, err := f.GetWithTTL(&dst)
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ttl <= 0 || ttl > 10*time.Second {
+		t.Errorf("%#v is not in (0, 10s]", ttl)
+	}
+
+	if want := (testConcrete{A: 1, B: "a"}); dst != want || !f.IsCached() {
+		t.Errorf("%#v is not %#v", dst, want)
+	}
+
+	// no expiration.
+	if err := f.Set(&testConcrete{A: 2}, 0); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if ttl, err := f.GetWithTTL(&dst); err != nil || ttl != 0 {
+		t.Errorf("%#v, %#v", ttl, err)
+	}
+}
+
+func TestGetWithTTLNotTTLGetter(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "ttl"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var dst string
+	if _, err := f.GetWithTTL(&dst); !errors.Is(err, cachefetcher.ErrNotTTLGetter) {
+		t.Errorf("%#v", err)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build go1.18
+// +build go1.18
+
+package cachefetcher
+
+import "time"
+
+// TypedFetcher is the type-safe CacheFetcher for the value type T.
+// The other methods, e.g. SetKey, are CacheFetcher's.
+type TypedFetcher[T any] struct {
+	CacheFetcher
+}
+
+// NewTyped returns TypedFetcher with a new fetcher of the client and the options.
+func NewTyped[T any](client Client, options *Options) *TypedFetcher[T] {
+	return &TypedFetcher[T]{CacheFetcher: NewFactory(client, options).NewFetcher()}
+}
+
+// Fetch is typed CacheFetcher.Fetch.
+func (t *TypedFetcher[T]) Fetch(expiration time.Duration, fetcher func() (T, error)) (T, error) {
+	var dst T
+	err := t.CacheFetcher.Fetch(expiration, &dst, fetcher)
+	return dst, err
+}
+
+// Get is typed CacheFetcher.Get.
+func (t *TypedFetcher[T]) Get() (T, error) {
+	var dst T
+	err := t.CacheFetcher.Get(&dst)
+	return dst, err
+}
+
+// Set is typed CacheFetcher.Set.
+func (t *TypedFetcher[T]) Set(value T, expiration time.Duration) error {
+	return t.CacheFetcher.Set(value, expiration)
+}
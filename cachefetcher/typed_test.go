@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package cachefetcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestTypedFetcher(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewTyped[testConcrete](redisClient, nil)
+	if err := f.SetKey([]string{"prefix", "key"}, "typed"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	want := testConcrete{A: 1, B: "b"}
+	got, err := f.Fetch(10*time.Second, func() (testConcrete, error) {
+		return want, nil
+	})
+	if err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if got != want {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	if got, err = f.Get(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if got != want || !f.IsCached() {
+		t.Errorf("%#v is not %#v", got, want)
+	}
+
+	want = testConcrete{A: 2}
+	if err := f.Set(want, 10*time.Second); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if got, err = f.Get(); err != nil || got != want {
+		t.Errorf("%#v is not %#v, %#v", got, want, err)
+	}
+}
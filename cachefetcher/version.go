@@ -0,0 +1,68 @@
+package cachefetcher
+
+import (
+	"errors"
+	"time"
+)
+
+// VersionedSetter is optional for Client to set the value only if the version is newer than the stored version.
+// The comparison and the write must be atomic, e.g. with the lua script.
+type VersionedSetter interface {
+	SetIfNewer(key, versionKey string, value interface{}, version int64, expiration time.Duration) (bool, error)
+}
+
+const versionSuffix = "version"
+
+// ErrNotVersionedSetter is the client does not implement VersionedSetter.
+var ErrNotVersionedSetter = errors.New("cachefetcher: client is not versioned setter")
+
+// SetIfNewer sets the cache only if version is greater than the stored version, and returns whether it is applied.
+// It prevents the out-of-order stale write, e.g. in the at-least-once delivery pipelines.
+// The version is stored in "<key>_version" with the same expiration, and it is kept after Del,
+// so the older version can not be written until it expires.
+// The client must implement VersionedSetter.
+func (f *cacheFetcherImpl) SetIfNewer(value interface{}, version int64, expiration time.Duration) (bool, error) {
+	start := f.options.Clock.Now()
+	ok, err := f.setIfNewer(value, version, expiration)
+	if err != nil {
+		return false, f.debugPrintErr(err, start)
+	}
+
+	if err := f.debugPrint(result{}, start); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+func (f *cacheFetcherImpl) setIfNewer(value interface{}, version int64, expiration time.Duration) (bool, error) {
+	f.setCached(false)
+	expiration, err := f.resolveExpiration(expiration)
+	if err != nil {
+		return false, err
+	}
+
+	c, ok := f.client.(VersionedSetter)
+	if !ok {
+		return false, ErrNotVersionedSetter
+	}
+
+	v, skip, err := f.storedValue(f.key, value, false)
+	if err != nil || skip {
+		return false, err // the skipped value is not applied.
+	}
+
+	var applied bool
+	err = f.withClientTimeout(func() (err error) {
+		applied, err = c.SetIfNewer(f.key, f.key+sep+versionSuffix, v, version, expiration)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if applied {
+		f.setCached(true)
+		f.setDone(f.key, value)
+	}
+	return applied, nil
+}
@@ -0,0 +1,107 @@
+package cachefetcher_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+)
+
+func TestSetIfNewer(t *testing.T) {
+	before()
+
+	f := factory.NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "version"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	tests := []struct {
+		value   string
+		version int64
+		applied bool
+		want    string
+	}{
+		{"v2", 2, true, "v2"},
+		{"v1", 1, false, "v2"}, // the out-of-order stale write.
+		{"v2'", 2, false, "v2"},
+		{"v3", 3, true, "v3"},
+	}
+
+	for _, tt := range tests {
+		applied, err := f.SetIfNewer(tt.value, tt.version, 10*time.Second)
+		if err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if applied != tt.applied {
+			t.Errorf("%#v: %#v is not %#v", tt.value, applied, tt.applied)
+		}
+
+		var dst string
+		if err := f.Get(&dst); err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if dst != tt.want {
+			t.Errorf("%#v is not %#v", dst, tt.want)
+		}
+	}
+}
+
+func TestSetIfNewerOptions(t *testing.T) {
+	before()
+
+	// the value is written as Set writes it.
+	f := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{StoreMeta: true, SourceTag: "v1", MaxValueBytes: 64}).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "version", "options"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if applied, err := f.SetIfNewer("value", 1, 10*time.Second); err != nil || !applied {
+		t.Errorf("%#v, %#v", applied, err)
+	}
+
+	if m, err := f.GetMeta(); err != nil || m.Source != "v1" || m.WrittenAt.IsZero() {
+		t.Errorf("%#v, %#v", m, err)
+	}
+
+	var dst string
+	if err := f.Get(&dst); err != nil || dst != "value" {
+		t.Errorf("%#v, %#v", dst, err)
+	}
+
+	large := strings.Repeat("a", 100)
+	_, err := f.SetIfNewer(large, 2, 10*time.Second)
+	if !errors.Is(err, cachefetcher.ErrValueTooLarge) || !strings.Contains(err.Error(), f.Key()) {
+		t.Errorf("%#v", err)
+	}
+
+	// the oversized value is not applied with SkipOversized.
+	sf := cachefetcher.NewFactory(redisClient, &cachefetcher.Options{MaxValueBytes: 64, SkipOversized: true}).NewFetcher()
+	if err := sf.SetKey([]string{"prefix", "key"}, "version", "skip"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if applied, err := sf.SetIfNewer(large, 1, 10*time.Second); err != nil || applied {
+		t.Errorf("%#v, %#v", applied, err)
+	}
+
+	if ok, err := sf.Exists(); err != nil || ok {
+		t.Errorf("%#v, %#v", ok, err)
+	}
+}
+
+func TestSetIfNewerNotVersionedSetter(t *testing.T) {
+	before()
+
+	f := cachefetcher.NewFactory(struct{ cachefetcher.Client }{redisClient}, nil).NewFetcher()
+	if err := f.SetKey([]string{"prefix", "key"}, "version"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := f.SetIfNewer("v1", 1, 10*time.Second); !errors.Is(err, cachefetcher.ErrNotVersionedSetter) {
+		t.Errorf("%#v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package cachefetchermetrics is the prometheus collector for cachefetcher.
+// It is a separate module so that cachefetcher does not depend on the prometheus client.
+package cachefetchermetrics
+
+import (
+	"strings"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is prometheus.Collector of cachefetcher's events.
+type Collector struct {
+	hits     *prometheus.CounterVec
+	misses   *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+const (
+	subsystem = "cachefetcher"
+	opLabel   = "op"
+)
+
+// NewCollector is new method for Collector.
+// The metrics are "<namespace>_cachefetcher_hits_total", "<namespace>_cachefetcher_misses_total",
+// "<namespace>_cachefetcher_errors_total" and "<namespace>_cachefetcher_operation_duration_seconds" with "op" label.
+func NewCollector(namespace string) *Collector {
+	labels := []string{opLabel}
+
+	return &Collector{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "hits_total",
+			Help:      "The number of the cache hits.",
+		}, labels),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "misses_total",
+			Help:      "The number of the cache misses.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "The number of the operation errors.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "The latency of the operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+	}
+}
+
+// Hook observes the event. Set it to cachefetcher.Options.DebugPrintHook.
+func (c *Collector) Hook(e cachefetcher.Event) {
+	op := opName(e.Op)
+
+	// the handled error e.g. decode error has no elapsed time.
+	if e.Elapsed > 0 {
+		c.duration.WithLabelValues(op).Observe(e.Elapsed.Seconds())
+	}
+
+	switch {
+	case e.Err != nil:
+		c.errors.WithLabelValues(op).Inc()
+	case !cachefetcher.IsReadOp(op):
+	case e.IsCached:
+		c.hits.WithLabelValues(op).Inc()
+	default:
+		c.misses.WithLabelValues(op).Inc()
+	}
+}
+
+// Describe is prometheus.Collector's Describe.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+	c.misses.Describe(ch)
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+// Collect is prometheus.Collector's Collect.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+	c.misses.Collect(ch)
+	c.errors.Collect(ch)
+	c.duration.Collect(ch)
+}
+
+// opName returns the method name of "cachefetcher.(*cacheFetcherImpl).Get".
+func opName(op string) string {
+	return op[strings.LastIndex(op, ".")+1:]
+}
@@ -0,0 +1,53 @@
+package cachefetchermetrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peutes/go-cache-fetcher/cachefetcher"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+func TestHook(t *testing.T) {
+	c := NewCollector("test")
+
+	events := []cachefetcher.Event{
+		{Op: "cachefetcher.(*cacheFetcherImpl).Get", IsCached: true, Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).Get", IsCached: true, Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).Get", Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).FetchWithContext", Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).GetInto", IsCached: true, Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).Set", IsCached: true, Elapsed: time.Millisecond},
+		{Op: "cachefetcher.(*cacheFetcherImpl).Set", Err: errors.New("error"), Elapsed: time.Millisecond},
+		{Op: "decode", Err: cachefetcher.ErrGobSerialized},
+	}
+	for _, e := range events {
+		c.Hook(e)
+	}
+
+	tests := []struct {
+		name string
+		c    prometheus.Collector
+		want float64
+	}{
+		{"hits Get", c.hits.WithLabelValues("Get"), 2},
+		{"misses Get", c.misses.WithLabelValues("Get"), 1},
+		{"misses Fetch", c.misses.WithLabelValues("FetchWithContext"), 1},
+		{"hits GetInto", c.hits.WithLabelValues("GetInto"), 1},
+		{"hits Set", c.hits.WithLabelValues("Set"), 0},
+		{"errors Set", c.errors.WithLabelValues("Set"), 1},
+		{"errors decode", c.errors.WithLabelValues("decode"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := testutil.ToFloat64(tt.c); v != tt.want {
+				t.Errorf("%#v: %#v is not %#v", tt.name, v, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/peutes/go-cache-fetcher/cachefetchermetrics
+
+go 1.15
+
+require (
+	github.com/peutes/go-cache-fetcher v0.0.0
+	github.com/prometheus/client_golang v1.9.0
+)
+
+replace github.com/peutes/go-cache-fetcher => ../